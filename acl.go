@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// accessRule is an allow: or deny: line, like
+//
+//	deny: DELETE,PUT /api/* from 10.0.0.0/8 auth
+//
+// It matches requests with one of the methods, or any for *, whose path
+// matches the glob, where a trailing /* also matches everything below. The
+// optional from limits it to client addresses and auth to requests with an
+// Authorization header.
+type accessRule struct {
+	allow   bool
+	methods []string
+	path    string
+	from    []netip.Prefix
+	auth    bool
+}
+
+func parseAccessRule(allow bool, value string) (accessRule, error) {
+	rule := accessRule{allow: allow}
+	fields := strings.Fields(value)
+	bad := fmt.Errorf("BAD access rule %q, want METHODS PATH [from CIDRS] [auth]", value)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "/") {
+		return rule, bad
+	}
+	if fields[0] != "*" {
+		rule.methods = strings.Split(strings.ToUpper(fields[0]), ",")
+	}
+	rule.path = fields[1]
+	if _, err := path.Match(rule.path, "/"); err != nil {
+		return rule, bad
+	}
+	for rest := fields[2:]; len(rest) > 0; rest = rest[1:] {
+		switch {
+		case rest[0] == "auth":
+			rule.auth = true
+		case rest[0] == "from" && len(rest) > 1:
+			var err error
+			if rule.from, err = parsePrefixes("from", rest[1]); err != nil {
+				return rule, err
+			}
+			rest = rest[1:]
+		default:
+			return rule, bad
+		}
+	}
+	return rule, nil
+}
+
+// accessRules are the allow: and deny: rules of the running app, or else of
+// its Procfile, so a denied client can't start the app either.
+func accessRules(name, dir string) []accessRule {
+	mu.Lock()
+	a := apps[name]
+	mu.Unlock()
+	if a != nil {
+		return a.pf.access
+	}
+	if pf, err := readProcfile(dir); err == nil {
+		return pf.access
+	}
+	return nil
+}
+
+func (rule accessRule) matches(r *http.Request) bool {
+	if rule.methods != nil && !contains(rule.methods, r.Method) {
+		return false
+	}
+	// Cleaned as the app will resolve it, so //admin or /x/../admin match
+	// rules for /admin.
+	p := path.Clean("/" + r.URL.Path)
+	if prefix, ok := strings.CutSuffix(rule.path, "/*"); ok {
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	} else if ok, _ := path.Match(rule.path, p); !ok {
+		return false
+	}
+	if rule.from != nil {
+		addr, ok := remoteAddr(r)
+		if !ok || !containsAddr(rule.from, addr) {
+			return false
+		}
+	}
+	return !rule.auth || r.Header.Get("Authorization") != ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed applies the first rule that matches r, and allows r if none does.
+func allowed(rules []accessRule, r *http.Request) bool {
+	for _, rule := range rules {
+		if rule.matches(r) {
+			return rule.allow
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessRules(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "guarded", "allow: DELETE /* auth\ndeny: DELETE,PUT /*\nallow: * /admin/* from 127.0.0.1\ndeny: * /admin/*\n")
+	request := func(method, path, remote string, auth bool) int {
+		r := httptest.NewRequest(method, "http://guarded.localhost"+path, nil)
+		r.RemoteAddr = remote + ":40000"
+		if auth {
+			r.Header.Set("Authorization", "Bearer x")
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	if code := request("DELETE", "/items/1", "127.0.0.1", false); code != http.StatusForbidden {
+		t.Errorf("DELETE without auth: status %d, want 403", code)
+	}
+	mu.Lock()
+	started := apps["guarded"] != nil
+	mu.Unlock()
+	if started {
+		t.Error("a denied request started the app")
+	}
+	for _, c := range []struct {
+		method, path, remote string
+		auth                 bool
+		want                 int
+	}{
+		{"GET", "/items/1", "192.0.2.1", false, http.StatusOK},
+		{"DELETE", "/items/1", "192.0.2.1", true, http.StatusOK},
+		{"PUT", "/items/1", "192.0.2.1", true, http.StatusForbidden},
+		{"GET", "/admin", "127.0.0.1", false, http.StatusOK},
+		{"POST", "/admin/users", "127.0.0.1", false, http.StatusOK},
+		{"GET", "/admin", "192.0.2.1", false, http.StatusForbidden},
+		{"GET", "/admin/users", "192.0.2.1", true, http.StatusForbidden},
+		{"GET", "/administrator", "192.0.2.1", false, http.StatusOK},
+		{"GET", "//admin/users", "192.0.2.1", false, http.StatusForbidden},
+		{"GET", "/x/../admin/users", "192.0.2.1", false, http.StatusForbidden},
+		{"GET", "/admin/./users", "192.0.2.1", false, http.StatusForbidden},
+		{"GET", "/admin/", "192.0.2.1", false, http.StatusForbidden},
+	} {
+		if code := request(c.method, c.path, c.remote, c.auth); code != c.want {
+			t.Errorf("%s %s from %s, auth %t: status %d, want %d", c.method, c.path, c.remote, c.auth, code, c.want)
+		}
+	}
+}
+
+func TestParseAccessRule(t *testing.T) {
+	for _, value := range []string{"GET", "GET api", "GET /[", "GET /api from", "GET /api from nowhere", "GET /api later"} {
+		if _, err := parseAccessRule(false, value); err == nil {
+			t.Errorf("parseAccessRule(%q) accepted", value)
+		}
+	}
+	rule, err := parseAccessRule(true, "get,post /api/* from 10.0.0.0/8 auth")
+	if err != nil || len(rule.methods) != 2 || rule.methods[0] != "GET" || len(rule.from) != 1 || !rule.auth {
+		t.Errorf("parseAccessRule = %+v, %v", rule, err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// activityFile lets processes without HTTP traffic, like background jobs
+// the web process spawns, keep their app from idling: touching it counts as
+// a request. Apps find its path in MUX_ACTIVITY.
+func activityFile(dir string) string {
+	return filepath.Join(dir, ".mux", "activity")
+}
+
+// lastActive is the later of a's last request and the last touch of its
+// activity file.
+func lastActive(a *appInfo) time.Time {
+	t := a.t
+	if fi, err := os.Stat(activityFile(a.dir)); err == nil && fi.ModTime().After(t) {
+		t = fi.ModTime()
+	}
+	return t
+}
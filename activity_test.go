@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActivityFile(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "jobs", "idle: 100ms\n")
+	path := get("jobs.localhost", "/env/MUX_ACTIVITY").Body.String()
+	if path != activityFile(filepath.Join(root, "jobs")) {
+		t.Fatalf("MUX_ACTIVITY = %q", path)
+	}
+	mu.Lock()
+	app := apps["jobs"]
+	mu.Unlock()
+	running := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["jobs"] == app
+	}
+
+	// A worker touches the file while it works, without any requests.
+	for range 8 {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		reapOnce()
+		if !running() {
+			t.Fatal("reaped while the worker signalled activity")
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	reapOnce()
+	if running() {
+		t.Error("not reaped once the worker stopped")
+	}
+}
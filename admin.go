@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin API listens on a unix socket only this user can open, unless
+// -admin also serves it over TCP.
+var (
+	adminAddr   = ""
+	adminSocket = "~/.cache/mux/admin.sock"
+)
+
+// adminMux serves the admin API. Command overrides choose what a shell runs,
+// so only the socket serves them.
+func adminMux(socket bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /apps", statusHandler)
+	mux.HandleFunc("GET /health/summary", healthSummaryHandler)
+	mux.HandleFunc("POST /apps/stop", stopAllHandler)
+	mux.HandleFunc("POST /gc", gcHandler)
+	mux.HandleFunc("GET /apps/{name}/logs", logsHandler)
+	if socket {
+		mux.HandleFunc("PUT /apps/{name}/command", setCommandHandler)
+		mux.HandleFunc("DELETE /apps/{name}/command", setCommandHandler)
+	}
+	mux.HandleFunc("POST /apps/{name}/reload", reloadHandler)
+	mux.HandleFunc("POST /apps/{name}/stop", stopHandler)
+	mux.HandleFunc("POST /apps/{name}/replay", replayHandler)
+	mux.HandleFunc("GET /apps/{name}/idle", idleHandler)
+	mux.HandleFunc("PUT /apps/{name}/idle", idleHandler)
+	mux.HandleFunc("/", adminFallback(mux))
+	return adminGuard(mux)
+}
+
+// adminFallback answers what no route takes with JSON, as ServeMux would:
+// 405 with Allow if other methods have the path, else 404.
+func adminFallback(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			probe := *r
+			probe.Method = method
+			if _, pattern := mux.Handler(&probe); pattern != "/" {
+				allow = append(allow, method)
+			}
+		}
+		if len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			writeAdminError(w, http.StatusMethodNotAllowed, "bad_method", r.Method+" not allowed on "+r.URL.Path)
+			return
+		}
+		writeAdminError(w, http.StatusNotFound, "not_found", "UNKNOWN path "+r.URL.Path)
+	}
+}
+
+// adminHeader marks requests from mux itself or other tools. Browsers send
+// it cross-origin only after a CORS preflight, which the admin API fails.
+const adminHeader = "X-Mux-Admin"
+
+// adminGuard only answers loopback Host values, so a page on a name that
+// rebinds to 127.0.0.1 can't drive the admin API from a browser, and only
+// takes changes with adminHeader or a JSON body, which a plain cross-origin
+// form or fetch can't send either.
+func adminGuard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host) {
+			writeAdminError(w, http.StatusMisdirectedRequest, "bad_host", "UNKNOWN host "+r.Host)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Header.Get(adminHeader) == "" && !jsonBody(r) {
+			writeAdminError(w, http.StatusForbidden, "forbidden", "MISSING "+adminHeader+" header or JSON body")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func jsonBody(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == "application/json"
+}
+
+func loopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+func serveAdmin() {
+	if adminSocket != "" {
+		if err := os.MkdirAll(filepath.Dir(adminSocket), 0700); err != nil {
+			log.Fatal(err)
+		}
+		ln, err := listenAdminSocket(adminSocket)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() { log.Fatal(http.Serve(ln, adminMux(true))) }()
+	}
+	if adminAddr != "" {
+		log.Fatal(http.ListenAndServe(adminAddr, adminMux(false)))
+	}
+}
+
+// listenAdminSocket listens on the unix socket at path, replacing a stale
+// socket a mux that exited left behind but not one another mux serves.
+func listenAdminSocket(path string) (net.Listener, error) {
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("admin socket %s is in use by another mux", path)
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// adminError is the JSON body of every admin API error, with a code that
+// tools can match on instead of the message.
+type adminError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func writeAdminError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(adminError{msg, code})
+}
+
+// adminApp resolves the {name} of r to an existing app directory.
+func adminApp(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := resolveName(r.PathValue("name"))
+	dir, err := appDir(name)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "bad_name", err.Error())
+		return "", false
+	}
+	if !isDir(dir) {
+		writeAdminError(w, http.StatusNotFound, "unknown_app", "UNKNOWN app "+name)
+		return "", false
+	}
+	return name, true
+}
+
+// runningApp resolves the {name} of r to a running app.
+func runningApp(w http.ResponseWriter, r *http.Request) (*appInfo, bool) {
+	name, ok := adminApp(w, r)
+	if !ok {
+		return nil, false
+	}
+	mu.Lock()
+	a := apps[name]
+	mu.Unlock()
+	if a == nil {
+		writeAdminError(w, http.StatusNotFound, "not_running", "NOT running: "+name)
+		return nil, false
+	}
+	return a, true
+}
+
+// adminClient talks to the admin endpoints over -admin-socket if set.
+func adminClient() (*http.Client, string) {
+	if adminSocket == "" {
+		addr := adminAddr
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		return http.DefaultClient, "http://" + addr
+	}
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", adminSocket)
+		},
+	}
+	return &http.Client{Transport: tr}, "http://localhost"
+}
+
+func setCommandHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := adminApp(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	command := strings.TrimSpace(string(body))
+	if r.Method == http.MethodPut && command == "" {
+		writeAdminError(w, http.StatusBadRequest, "bad_request", "EMPTY command")
+		return
+	}
+
+	mu.Lock()
+	if command == "" {
+		delete(overrides, name)
+	} else {
+		overrides[name] = command
+	}
+	a := apps[name]
+	mu.Unlock()
+	if a != nil {
+		stopApp(a)
+	}
+	if command == "" {
+		infof("OVERRIDE: %s cleared", name)
+		fmt.Fprintf(w, "%s: using Procfile\n", name)
+	} else {
+		infof("OVERRIDE: %s %s", name, command)
+		fmt.Fprintf(w, "%s: %s\n", name, command)
+	}
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := runningApp(w, r)
+	if !ok {
+		return
+	}
+	reloadApp(a, "admin")
+	fmt.Fprintf(w, "%s: reloading\n", a.name)
+}
+
+func stopHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := runningApp(w, r)
+	if !ok {
+		return
+	}
+	stopApp(a)
+	fmt.Fprintf(w, "%s: stopped\n", a.name)
+}
+
+func stopAllHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	running := make([]*appInfo, 0, len(apps))
+	for _, a := range apps {
+		running = append(running, a)
+	}
+	mu.Unlock()
+	sort.Slice(running, func(i, j int) bool { return running[i].name < running[j].name })
+	for _, a := range running {
+		stopApp(a)
+		fmt.Fprintf(w, "%s: stopped\n", a.name)
+	}
+}
+
+func idleHandler(w http.ResponseWriter, r *http.Request) {
+	var ttl time.Duration
+	if r.Method == http.MethodPut {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		var err error
+		if s := strings.TrimSpace(string(body)); s != "" {
+			if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+				writeAdminError(w, http.StatusBadRequest, "bad_request", "BAD idle "+s+", want a duration like 1h")
+				return
+			}
+		}
+	}
+	a, ok := runningApp(w, r)
+	if !ok {
+		return
+	}
+	name := a.name
+	mu.Lock()
+	if r.Method == http.MethodPut {
+		a.idle = ttl
+	}
+	effective := appIdleTTL(a)
+	mu.Unlock()
+	if r.Method == http.MethodPut {
+		infof("IDLE TTL: %s %s", name, effective)
+	}
+	fmt.Fprintf(w, "%s: %s\n", name, effective)
+}
+
+func adminRequest(method, path string, body io.Reader) error {
+	client, base := adminClient()
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(adminHeader, "1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(resp.Body)
+		var e adminError
+		if json.Unmarshal(msg, &e) == nil && e.Error != "" {
+			msg = []byte(e.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, strings.TrimSpace(string(msg)))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+func runOverride(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("USAGE: mux -run APP [-- COMMAND...]")
+	}
+	name, command := args[0], args[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		return adminRequest(http.MethodDelete, "/apps/"+name+"/command", nil)
+	}
+	return adminRequest(http.MethodPut, "/apps/"+name+"/command", strings.NewReader(strings.Join(command, " ")))
+}
+
+func runReload(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("USAGE: mux -reload APP")
+	}
+	return adminRequest(http.MethodPost, "/apps/"+args[0]+"/reload", nil)
+}
+
+func runStop(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("USAGE: mux -stop APP")
+	}
+	return adminRequest(http.MethodPost, "/apps/"+args[0]+"/stop", nil)
+}
+
+func runLogs(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("USAGE: mux -logs APP")
+	}
+	return adminRequest(http.MethodGet, "/apps/"+args[0]+"/logs", nil)
+}
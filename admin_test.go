@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// admin sends a request to the admin endpoints served on -admin-socket.
+func admin(method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	if method != "GET" {
+		r.Header.Set(adminHeader, "1")
+	}
+	w := httptest.NewRecorder()
+	adminMux(true).ServeHTTP(w, r)
+	return w
+}
+
+// testAdminSocket serves the admin endpoints on a socket for the test, which
+// -run, -reload and the other commands talk to.
+func testAdminSocket(t *testing.T) {
+	t.Helper()
+	saved := adminSocket
+	adminSocket = filepath.Join(t.TempDir(), "admin.sock")
+	t.Cleanup(func() { adminSocket = saved })
+	serveAdmin()
+}
+
+// adminCode returns the code of an admin error response.
+func adminCode(w *httptest.ResponseRecorder) string {
+	var e struct{ Code string }
+	_ = json.Unmarshal(w.Body.Bytes(), &e)
+	return e.Code
+}
+
+// args returns the arguments the app on host was started with.
+func args(t *testing.T, host string) string {
+	t.Helper()
+	w := get(host, "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", host, w.Code, w.Body)
+	}
+	_, args, _ := strings.Cut(w.Body.String(), "args=")
+	return args
+}
+
+func TestCommandOverride(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "pinned", "")
+	testAdminSocket(t)
+
+	if got := args(t, "pinned.localhost"); got != "" {
+		t.Fatalf("started with %q, want the Procfile command", got)
+	}
+	var err error
+	out := stdout(t, func() { err = runOverride([]string{"pinned", "--", testBin, "--debug"}) })
+	if err != nil || out != "pinned: "+testBin+" --debug\n" {
+		t.Fatalf("mux -run: %v %q", err, out)
+	}
+	if got := args(t, "pinned.localhost"); got != "--debug" {
+		t.Errorf("started with %q after -run, want --debug", got)
+	}
+
+	mu.Lock()
+	first := apps["pinned"]
+	mu.Unlock()
+	reloadApp(first, "main.go")
+	waitFor(t, "the reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["pinned"] != first
+	})
+	if got := args(t, "pinned.localhost"); got != "--debug" {
+		t.Errorf("reloaded with %q, want the override kept", got)
+	}
+
+	out = stdout(t, func() { err = runOverride([]string{"pinned"}) })
+	if err != nil || out != "pinned: using Procfile\n" {
+		t.Fatalf("mux -run without a command: %v %q", err, out)
+	}
+	if got := args(t, "pinned.localhost"); got != "" {
+		t.Errorf("started with %q once cleared, want the Procfile command", got)
+	}
+}
+
+func TestCommandOverrideSocketOnly(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "pinned", "")
+	r := httptest.NewRequest("PUT", "http://localhost/apps/pinned/command", strings.NewReader("sh -c id"))
+	r.Header.Set(adminHeader, "1")
+	w := httptest.NewRecorder()
+	adminMux(false).ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("override over TCP: %d %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	_, ok := overrides["pinned"]
+	mu.Unlock()
+	if ok {
+		t.Error("override set over TCP")
+	}
+}
+
+func TestReloadCommand(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "reloaded", "")
+	testApp(t, root, "stopped", "")
+	testAdminSocket(t)
+	metricsMu.Lock()
+	delete(metrics, "reloaded")
+	metricsMu.Unlock()
+	get("reloaded.localhost", "/")
+	mu.Lock()
+	first := apps["reloaded"]
+	mu.Unlock()
+
+	began := time.Now()
+	var err error
+	out := stdout(t, func() { err = runReload([]string{"reloaded"}) })
+	if err != nil || out != "reloaded: reloading\n" {
+		t.Fatalf("mux -reload: %v %q", err, out)
+	}
+	waitFor(t, "the reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["reloaded"] != first
+	})
+	if took := time.Since(began); took > time.Second {
+		t.Errorf("reload took %s", took)
+	}
+	if n := metricsFor("reloaded").watchReloads.Load(); n != 1 {
+		t.Errorf("%d reloads, want 1", n)
+	}
+
+	if err := runReload([]string{"stopped"}); err == nil || !strings.Contains(err.Error(), "NOT running: stopped") {
+		t.Errorf("reloading a stopped app: %v", err)
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	root := testRoot(t)
+	names := []string{"alpha", "beta", "gamma"}
+	for _, name := range names {
+		testApp(t, root, name, "")
+		if body := get(name+".localhost", "/").Body.String(); !strings.HasPrefix(body, "PORT=") {
+			t.Fatalf("%s: %q", name, body)
+		}
+	}
+	mu.Lock()
+	var started []*appInfo
+	for _, a := range apps {
+		started = append(started, a)
+	}
+	mu.Unlock()
+	testAdminSocket(t)
+
+	var err error
+	out := stdout(t, func() { err = adminRequest(http.MethodPost, "/apps/stop", nil) })
+	if want := "alpha: stopped\nbeta: stopped\ngamma: stopped\n"; err != nil || out != want {
+		t.Fatalf("mux -stop-all: %v %q, want %q", err, out, want)
+	}
+	mu.Lock()
+	left := len(apps)
+	mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d apps still running", left)
+	}
+	for _, a := range started {
+		select {
+		case <-a.exited:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: process still running", a.name)
+		}
+	}
+	if body := get("beta.localhost", "/").Body.String(); !strings.HasPrefix(body, "PORT=") {
+		t.Errorf("beta did not start again after -stop-all: %q", body)
+	}
+}
+
+func TestStopCommand(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "stopped", "")
+	testApp(t, root, "kept", "")
+	get("stopped.localhost", "/")
+	get("kept.localhost", "/")
+	mu.Lock()
+	app := apps["stopped"]
+	mu.Unlock()
+	testAdminSocket(t)
+
+	var err error
+	out := stdout(t, func() { err = runStop([]string{"stopped"}) })
+	if err != nil || out != "stopped: stopped\n" {
+		t.Fatalf("mux -stop: %v %q", err, out)
+	}
+	select {
+	case <-app.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process still running")
+	}
+	mu.Lock()
+	_, running := apps["stopped"]
+	_, kept := apps["kept"]
+	mu.Unlock()
+	if running || !kept {
+		t.Errorf("after -stop: stopped running %v, kept running %v", running, kept)
+	}
+	if err := runStop([]string{"stopped"}); err == nil || !strings.Contains(err.Error(), "NOT running: stopped") {
+		t.Errorf("stopping a stopped app: %v", err)
+	}
+}
+
+func TestIdleOverride(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "demo", "idle: 50ms\n")
+	if w := admin("GET", "/apps/demo/idle", ""); w.Code != http.StatusNotFound || adminCode(w) != "not_running" {
+		t.Errorf("stopped app: status %d: %s", w.Code, w.Body)
+	}
+	get("demo.localhost", "/")
+	if w := admin("GET", "/apps/demo/idle", ""); w.Body.String() != "demo: 50ms\n" {
+		t.Errorf("GET idle = %q, want the Procfile's 50ms", w.Body)
+	}
+	if w := admin("PUT", "/apps/demo/idle", "soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT idle soon: status %d", w.Code)
+	}
+	if w := admin("PUT", "/apps/demo/idle", "1h"); w.Body.String() != "demo: 1h0m0s\n" {
+		t.Errorf("PUT idle 1h = %q", w.Body)
+	}
+	mu.Lock()
+	app := apps["demo"]
+	mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	reapOnce()
+	mu.Lock()
+	survived := apps["demo"] == app
+	mu.Unlock()
+	if !survived {
+		t.Fatal("reaped after the Procfile's idle despite the 1h override")
+	}
+
+	if w := admin("PUT", "/apps/demo/idle", ""); w.Body.String() != "demo: 50ms\n" {
+		t.Errorf("clearing the override = %q", w.Body)
+	}
+	reapOnce()
+	mu.Lock()
+	reaped := apps["demo"] == nil
+	mu.Unlock()
+	if !reaped {
+		t.Error("not reaped once the override was cleared")
+	}
+	<-app.exited
+	get("demo.localhost", "/")
+	if w := admin("GET", "/apps/demo/idle", ""); w.Body.String() != "demo: 50ms\n" {
+		t.Errorf("override survived a restart: %q", w.Body)
+	}
+}
+
+func TestStatusOverSocket(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	writeFiles(t, root, map[string]string{"site/index.html": ""})
+	get("api.localhost", "/")
+	savedAddr := adminAddr
+	adminAddr = ""
+	defer func() { adminAddr = savedAddr }()
+	testAdminSocket(t)
+	if fi, err := os.Stat(adminSocket); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket %v, %v, want mode 0600", fi, err)
+	}
+
+	var err error
+	out := stdout(t, func() { err = adminRequest(http.MethodGet, "/apps", nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "APP ") {
+		t.Fatalf("mux -status printed %q", out)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "api" || fields[2] != "running" {
+		t.Errorf("api line %q, want running", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "site" || fields[1] != "static" {
+		t.Errorf("site line %q, want static", lines[2])
+	}
+}
+
+func TestAdminSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	ln, err := listenAdminSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenAdminSocket(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("second listen: %v, want in use", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("served socket removed: %v", err)
+	}
+
+	// Closing removes the file, so leave a stale one as a killed mux does.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenAdminSocket(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+}
+
+func TestAdminErrors(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/apps/missing/reload", "", http.StatusNotFound, "unknown_app"},
+		{"POST", "/apps/api/reload", "", http.StatusNotFound, "not_running"},
+		{"POST", "/apps/..%2Fetc/reload", "", http.StatusBadRequest, "bad_name"},
+		{"PUT", "/apps/api/idle", "soon", http.StatusBadRequest, "bad_request"},
+		{"DELETE", "/apps/api/reload", "", http.StatusMethodNotAllowed, "bad_method"},
+		{"GET", "/nothing/here", "", http.StatusNotFound, "not_found"},
+	} {
+		w := admin(c.method, c.path, c.body)
+		var e adminError
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: %q is not a JSON error: %v", c.method, c.path, w.Body, err)
+			continue
+		}
+		if w.Code != c.status || e.Code != c.code || e.Error == "" {
+			t.Errorf("%s %s: %d %+v, want %d with code %s", c.method, c.path, w.Code, e, c.status, c.code)
+		}
+	}
+	if w := admin("DELETE", "/apps/api/reload", ""); w.Header().Get("Allow") != "POST" {
+		t.Errorf("Allow = %q, want POST", w.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var aliases = map[string]string{}
+
+func parseAliases(spec string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		alias, app, ok := strings.Cut(item, "=")
+		if !ok || !validName(alias) || !validName(app) {
+			return nil, fmt.Errorf("BAD -alias %q, want ALIAS=APP", item)
+		}
+		if prev, ok := m[alias]; ok && prev != app {
+			return nil, fmt.Errorf("BAD -alias %q: %s is already an alias for %s", item, alias, prev)
+		}
+		m[alias] = app
+	}
+	return m, nil
+}
+
+// subdomains is how a host with several labels before -host names its app:
+// exact uses them all, so a.b.localhost is app "a.b"; leftmost uses "a" and
+// rightmost uses "b", so any prefix reaches app b.
+var subdomains = "exact"
+
+func subdomainName(name string) string {
+	switch subdomains {
+	case "leftmost":
+		name, _, _ = strings.Cut(name, ".")
+	case "rightmost":
+		name = name[strings.LastIndex(name, ".")+1:]
+	}
+	return name
+}
+
+// resolveName maps an alias from -alias to the app it stands for, unless an
+// app by that name exists.
+func resolveName(name string) string {
+	app, ok := aliases[name]
+	if !ok {
+		return name
+	}
+	for _, root := range roots {
+		if isDir(rootDir(root, name)) {
+			return name
+		}
+	}
+	return app
+}
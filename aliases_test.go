@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	got, err := parseAliases(" api=my-long-service-name, docs=site ,")
+	if err != nil || len(got) != 2 || got["api"] != "my-long-service-name" || got["docs"] != "site" {
+		t.Errorf("parseAliases = %v, %v", got, err)
+	}
+	for _, spec := range []string{"api", "=app", "api=", "a/b=app", "api=one,api=two"} {
+		if _, err := parseAliases(spec); err == nil {
+			t.Errorf("parseAliases(%q) accepted", spec)
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "my-long-service-name", "")
+	testApp(t, root, "shadow", "")
+	saved := aliases
+	aliases = map[string]string{"api": "my-long-service-name", "shadow": "my-long-service-name"}
+	defer func() { aliases = saved }()
+
+	if w := get("api.localhost", "/"); w.Code != http.StatusOK {
+		t.Fatalf("alias: status %d: %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	first := apps["my-long-service-name"]
+	_, alias := apps["api"]
+	mu.Unlock()
+	if first == nil || alias {
+		t.Errorf("running the aliased app %v, an app named for the alias %v", first != nil, alias)
+	}
+	if w := admin("POST", "/apps/api/reload", ""); w.Code != http.StatusOK || w.Body.String() != "my-long-service-name: reloading\n" {
+		t.Errorf("reload by alias: status %d: %s", w.Code, w.Body)
+	}
+	waitFor(t, "the reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		a := apps["my-long-service-name"]
+		return a != nil && a != first
+	})
+
+	get("shadow.localhost", "/")
+	mu.Lock()
+	_, shadowed := apps["shadow"]
+	mu.Unlock()
+	if !shadowed {
+		t.Error("an alias shadowed the app of the same name")
+	}
+}
+
+func TestAliasConflict(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{"docs/index.html": "docs", "site/index.html": "site"})
+	saved := aliases
+	aliases = map[string]string{"docs": "site", "www": "site"}
+	defer func() { aliases = saved }()
+
+	buf := captureLog(t, levelError)
+	if _, err := discoverApps(); err != nil {
+		t.Fatal(err)
+	}
+	want := "-alias docs=site shadows app docs in " + root + ", serving the app"
+	if got := buf.String(); !strings.Contains(got, "CONFLICT: "+want) || strings.Count(got, "CONFLICT") != 1 {
+		t.Errorf("logged %q, want one CONFLICT: %s", got, want)
+	}
+	list := appList(t, "")
+	if st := list["docs"]; st.Conflict != want {
+		t.Errorf("status conflict for docs = %q, want %q", st.Conflict, want)
+	}
+	if st := list["site"]; st.Conflict != "" {
+		t.Errorf("status conflict for site = %q", st.Conflict)
+	}
+	if w := get("docs.localhost", "/"); w.Body.String() != "docs" {
+		t.Errorf("shadowed alias served %q, want the app", w.Body)
+	}
+}
+
+func TestSubdomains(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{"a.b/index.html": "a.b", "a/index.html": "a", "b/index.html": "b"})
+	saved := subdomains
+	defer func() { subdomains = saved }()
+	for rule, want := range map[string]string{"exact": "a.b", "leftmost": "a", "rightmost": "b"} {
+		subdomains = rule
+		if w := get("a.b.localhost", "/"); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("-subdomains %s: a.b.localhost got %d %q, want app %s", rule, w.Code, w.Body, want)
+		}
+		if w := get("b.localhost", "/"); w.Body.String() != "b" {
+			t.Errorf("-subdomains %s: b.localhost got %q, want app b", rule, w.Body)
+		}
+	}
+}
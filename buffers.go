@@ -0,0 +1,22 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// copyBufferSize bounds the memory a proxied response or TCP stream uses, no
+// matter how large the body is. It also sizes the transport's buffers to each
+// app. flushInterval is the proxy's FlushInterval: 0 flushes only when a
+// buffer fills, except for streams, negative flushes after every write.
+var (
+	copyBufferSize = 32 << 10
+	flushInterval  time.Duration
+)
+
+type bufferPool struct{ sync.Pool }
+
+var copyBuffers = &bufferPool{sync.Pool{New: func() any { return make([]byte, copyBufferSize) }}}
+
+func (p *bufferPool) Get() []byte  { return p.Pool.Get().([]byte) }
+func (p *bufferPool) Put(b []byte) { p.Pool.Put(b) }
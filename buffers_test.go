@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// zeros reads as an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countingWriter is a ResponseWriter that keeps only the byte count.
+type countingWriter struct {
+	header http.Header
+	code   int
+	n      int64
+}
+
+func (w *countingWriter) Header() http.Header  { return w.header }
+func (w *countingWriter) WriteHeader(code int) { w.code = code }
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestProxyStreamsLargeBody(t *testing.T) {
+	const size = 256 << 20
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		io.CopyN(w, zeros{}, size)
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	cmd, exited := testProcess(t)
+	app := newAppInfo("download", t.TempDir(), &procfile{}, addr.IP.String(), addr.Port, cmd)
+	app.exited = exited
+	defer stopApp(app)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	w := &countingWriter{header: http.Header{}}
+	app.p.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	runtime.ReadMemStats(&after)
+
+	if w.n != size {
+		t.Fatalf("proxied %d bytes, want %d", w.n, size)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Errorf("proxying %dMB allocated %dMB", size>>20, alloc>>20)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second")
+	}))
+	defer backend.Close()
+	defer close(release)
+	addr := backend.Listener.Addr().(*net.TCPAddr)
+	savedSize, savedInterval := copyBufferSize, flushInterval
+	copyBufferSize, flushInterval = 4096, -1
+	defer func() { copyBufferSize, flushInterval = savedSize, savedInterval }()
+	cmd, exited := testProcess(t)
+	app := newAppInfo("live", t.TempDir(), &procfile{}, addr.IP.String(), addr.Port, cmd)
+	app.exited = exited
+	defer stopApp(app)
+	if app.p.FlushInterval != -1 || app.tr.ReadBufferSize != 4096 || app.tr.WriteBufferSize != 4096 {
+		t.Errorf("proxy flushes every %s with %d/%d byte buffers, want -1ns and 4096", app.p.FlushInterval, app.tr.ReadBufferSize, app.tr.WriteBufferSize)
+	}
+
+	front := httptest.NewServer(app.p)
+	defer front.Close()
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil || string(buf) != "first" {
+		t.Errorf("read %q, %v before the app finished, want first", buf, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func checkApps(names []string) bool {
+	if len(names) == 0 {
+		var err error
+		if names, err = discoverApps(); err != nil {
+			fmt.Println(err)
+			return false
+		}
+	}
+	ok := true
+	for _, name := range names {
+		dir, err := appDir(name)
+		if err == nil {
+			_, err = os.Stat(dir)
+		}
+		if err != nil {
+			fmt.Printf("FAIL: %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+		if !isDynamic(dir) {
+			fmt.Printf("OK: %s (static)\n", name)
+			continue
+		}
+		_, problems, err := parseProcfile(dir)
+		if err != nil {
+			fmt.Printf("FAIL: %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+		failed := false
+		for _, p := range problems {
+			if p.warn {
+				fmt.Printf("WARN: %v\n", p)
+			} else {
+				fmt.Printf("FAIL: %v\n", p)
+				failed = true
+			}
+		}
+		if failed {
+			ok = false
+		} else {
+			fmt.Printf("OK: %s\n", name)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stdout returns what f prints. It goes through a file, not a pipe, which
+// apps that f starts would hold open. Starts and reloads f leaves running
+// read os.Stdout too, so it is restored only once they are done.
+func stdout(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = saved }()
+	f()
+	waitFor(t, "background starts", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, a := range apps {
+			if a.reloading {
+				return false
+			}
+		}
+		return len(starting) == 0
+	})
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCheckApps(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{
+		"good/Procfile":   "web: ./server\nidle: 5m\n",
+		"bad/Procfile":    "# comment\nidle: soon\nweb ./server\ncolour: blue\n",
+		"static/index.md": "",
+	})
+	bad := filepath.Join(root, "bad", "Procfile")
+
+	var ok bool
+	out := stdout(t, func() { ok = checkApps(nil) })
+	want := "FAIL: " + bad + ":2: BAD idle: soon\n" +
+		"WARN: " + bad + ":3: NO ':' in \"web ./server\"\n" +
+		"WARN: " + bad + ":4: UNKNOWN directive colour:\n" +
+		"FAIL: " + bad + ": NO web:\n" +
+		"OK: good\n" +
+		"OK: static (static)\n"
+	if ok || out != want {
+		t.Errorf("checkApps() = %v, printed\n%s\nwant false and\n%s", ok, out, want)
+	}
+
+	out = stdout(t, func() { ok = checkApps([]string{"good"}) })
+	if !ok || out != "OK: good\n" {
+		t.Errorf("checkApps(good) = %v, printed %q", ok, out)
+	}
+	out = stdout(t, func() { ok = checkApps([]string{"missing"}) })
+	if ok {
+		t.Errorf("checkApps(missing) passed, printed %q", out)
+	}
+}
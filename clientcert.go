@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// clientCert asks TLS clients for a certificate and forwards it to apps,
+// which do their own verification.
+var clientCert = false
+
+var clientCertHeaders = []string{"X-Client-Cert-Subject", "X-Client-Cert-SAN", "X-Client-Cert-Fingerprint"}
+
+// setClientCert replaces the X-Client-Cert-* headers with the certificate
+// the client presented, if any, so clients can't forge them.
+func setClientCert(r *http.Request) {
+	for _, h := range clientCertHeaders {
+		r.Header.Del(h)
+	}
+	if !clientCert || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return
+	}
+	cert := r.TLS.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	r.Header.Set("X-Client-Cert-Subject", cert.Subject.String())
+	if san := certSANs(cert); san != "" {
+		r.Header.Set("X-Client-Cert-SAN", san)
+	}
+	r.Header.Set("X-Client-Cert-Fingerprint", hex.EncodeToString(sum[:]))
+}
+
+func certSANs(cert *x509.Certificate) string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return strings.Join(sans, ", ")
+}
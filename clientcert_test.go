@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// clientCertificate returns a self-signed certificate for alice.
+func clientCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		DNSNames:       []string{"alice.example"},
+		EmailAddresses: []string{"alice@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCert(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "mtls", "")
+	cert := clientCertificate(t)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	withCert := &http.Client{Transport: tr}
+	header := func(client *http.Client, name string) string {
+		req, _ := http.NewRequest("GET", srv.URL+"/header/"+name, nil)
+		req.Host = "mtls.localhost"
+		req.Header.Set(name, "forged")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := header(withCert, "X-Client-Cert-Subject"); got != "" {
+		t.Errorf("without -client-cert the app saw subject %q", got)
+	}
+	clientCert = true
+	defer func() { clientCert = false }()
+	sum := sha256.Sum256(cert.Certificate[0])
+	for name, want := range map[string]string{
+		"X-Client-Cert-Subject":     "CN=alice,O=Example",
+		"X-Client-Cert-SAN":         "alice.example, alice@example.com",
+		"X-Client-Cert-Fingerprint": hex.EncodeToString(sum[:]),
+	} {
+		if got := header(withCert, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		if got := header(srv.Client(), name); got != "" {
+			t.Errorf("%s = %q without a client certificate", name, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// startWait bounds how long a request waits for its app to start, and
+// startQueue how many requests may wait per app. Zero means no limit.
+var (
+	startWait  time.Duration
+	startQueue = 0
+	queued     = map[string]int{}
+)
+
+// waitApp is getApp with the -start-wait and -start-queue limits. A request
+// that gives up leaves the start running for the ones after it.
+func waitApp(name, dir string) (*appInfo, error) {
+	if startWait == 0 && startQueue == 0 {
+		return getApp(name, dir)
+	}
+	mu.Lock()
+	if _, ok := apps[name]; ok {
+		mu.Unlock()
+		return getApp(name, dir)
+	}
+	if startQueue > 0 && queued[name] >= startQueue {
+		mu.Unlock()
+		return nil, &statusError{http.StatusServiceUnavailable, fmt.Errorf("QUEUE FULL: %s has %d requests waiting to start", name, startQueue)}
+	}
+	queued[name]++
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		if queued[name]--; queued[name] == 0 {
+			delete(queued, name)
+		}
+		mu.Unlock()
+	}()
+
+	type result struct {
+		a   *appInfo
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		a, err := getApp(name, dir)
+		done <- result{a, err}
+	}()
+	var timeout <-chan time.Time
+	if startWait > 0 {
+		timer := time.NewTimer(startWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case r := <-done:
+		return r.a, r.err
+	case <-timeout:
+		return nil, &statusError{http.StatusServiceUnavailable, fmt.Errorf("STARTING %s took over %s, retry shortly", name, startWait)}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartQueue(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "slow", "")
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\nMUX_TEST_DELAY=800ms\n"})
+	startWait, startQueue = 150*time.Millisecond, 2
+	defer func() { startWait, startQueue = 0, 0 }()
+
+	var wg sync.WaitGroup
+	var bodies [4]string
+	var codes [4]int
+	var took [4]time.Duration
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			began := time.Now()
+			w := get("slow.localhost", "/")
+			bodies[i], codes[i], took[i] = w.Body.String(), w.Code, time.Since(began)
+		}()
+	}
+	wg.Wait()
+	waited, full := 0, 0
+	for i, body := range bodies {
+		if codes[i] != http.StatusServiceUnavailable {
+			t.Errorf("request %d: status %d, want 503", i, codes[i])
+		}
+		switch {
+		case strings.Contains(body, "STARTING slow took over 150ms"):
+			waited++
+			if took[i] < 150*time.Millisecond || took[i] > 500*time.Millisecond {
+				t.Errorf("request %d waited %s, want about 150ms", i, took[i])
+			}
+		case strings.Contains(body, "QUEUE FULL: slow has 2 requests waiting"):
+			full++
+		}
+	}
+	if waited != 2 || full != 2 {
+		t.Errorf("%d requests waited and %d found the queue full, want 2 each: %q", waited, full, bodies)
+	}
+	waitFor(t, "the start to finish", func() bool {
+		return get("slow.localhost", "/").Code == http.StatusOK
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printConfig prints the settings mux runs with, as passed to the service,
+// and whether each came from a flag or its default.
+func printConfig(w io.Writer, args []string) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tFROM")
+	for _, arg := range args {
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		from := "default"
+		if set[name] {
+			from = "flag"
+		}
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, from)
+	}
+	_ = tw.Flush()
+}
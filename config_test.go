@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestPrintConfig(t *testing.T) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+	flag.CommandLine = flag.NewFlagSet("mux", flag.ContinueOnError)
+	flag.String("idle", "5m", "")
+	flag.String("host", "localhost", "")
+	flag.String("alias", "", "")
+	if err := flag.CommandLine.Parse([]string{"-idle", "1h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	printConfig(&out, []string{"-idle=1h", "-host=localhost", "-alias="})
+	want := [][]string{
+		{"FLAG", "VALUE", "FROM"},
+		{"idle", "1h", "flag"},
+		{"host", "localhost", "default"},
+		{"alias", "-", "default"},
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("printed %q", out.String())
+	}
+	for i, line := range lines {
+		if got := strings.Fields(line); strings.Join(got, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d = %q, want %q", i, line, want[i])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+var dashboardOn = false
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>mux</title><meta http-equiv="refresh" content="5"></head>
+<body style="font-family: sans-serif; margin: 3em">
+<h1>mux</h1>
+<table cellpadding="6">
+<tr><th align="left">App</th><th align="left">Type</th><th align="left">State</th><th align="left">Port</th></tr>
+{{range .}}<tr>
+<td><a href="{{.URL}}">{{.Name}}</a></td><td>{{.Type}}</td><td>{{.State}}</td><td>{{if .Port}}{{.Port}}{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="4">No apps yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type dashboardApp struct {
+	*appStatus
+	URL   string
+	State string
+}
+
+// serveDashboard lists all apps with their state, for the apex host when
+// there is no www app.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	names, _, err := cachedScan()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var list []dashboardApp
+	for _, name := range names {
+		st, err := statusOf(name)
+		if err != nil {
+			continue
+		}
+		app := dashboardApp{appStatus: st, URL: fmt.Sprintf("%s://%s.%s", requestScheme(r), name, r.Host), State: "stopped"}
+		switch {
+		case st.Type == "static":
+			app.State = "-"
+		case st.Degraded != "":
+			app.State = "degraded"
+		case st.Running:
+			app.State = "running"
+		}
+		list = append(list, app)
+	}
+	var buf bytes.Buffer
+	if err := dashboardPage.Execute(&buf, list); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
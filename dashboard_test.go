@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	testApp(t, root, "worker", "")
+	writeFiles(t, root, map[string]string{"site/index.html": "site"})
+	get("api.localhost", "/")
+	dashboardOn = true
+	defer func() { dashboardOn = false }()
+
+	w := get("localhost", "/")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="http://api.localhost">api</a></td><td>dynamic</td><td>running</td>`,
+		`<a href="http://worker.localhost">worker</a></td><td>dynamic</td><td>stopped</td>`,
+		`<a href="http://site.localhost">site</a></td><td>static</td><td>-</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard lacks %s:\n%s", want, body)
+		}
+	}
+
+	writeFiles(t, root, map[string]string{"www/index.html": "home"})
+	if w := get("localhost", "/"); w.Body.String() != "home" {
+		t.Errorf("apex with a www app served %q, want the app", w.Body)
+	}
+}
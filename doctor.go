@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+type doctorCheck struct {
+	name     string
+	critical bool
+	run      func() error
+}
+
+func doctorChecks() []doctorCheck {
+	checks := []doctorCheck{
+		{"host " + domain, false, checkHost},
+		{"port " + port, true, func() error { return checkListen(":"+port, "-port") }},
+		{"shell", false, checkShell},
+		{"inotify watches", false, checkInotify},
+	}
+	if adminAddr != "" {
+		checks = append(checks, doctorCheck{"admin " + adminAddr, false, func() error { return checkListen(adminAddr, "-admin") }})
+	}
+	for _, root := range roots {
+		checks = append(checks, doctorCheck{"dir " + root, true, func() error { return checkWritable(root) }})
+	}
+	return checks
+}
+
+// runDoctor prints a line per check and reports whether all critical
+// checks passed.
+func runDoctor() bool {
+	ok := true
+	for _, c := range doctorChecks() {
+		err := c.run()
+		switch {
+		case err == nil:
+			fmt.Printf("OK: %s\n", c.name)
+		case c.critical:
+			fmt.Printf("FAIL: %s: %v\n", c.name, err)
+			ok = false
+		default:
+			fmt.Printf("WARN: %s: %v\n", c.name, err)
+		}
+	}
+	return ok
+}
+
+func checkHost() error {
+	host := "mux-doctor." + domain
+	local := domain == "localhost" || strings.HasSuffix(domain, ".localhost")
+	addrs, err := net.LookupHost(host)
+	if err != nil && local {
+		return fmt.Errorf("%v, browsers resolve *.localhost themselves but other tools need a resolver like dnsmasq", err)
+	}
+	if err != nil {
+		return fmt.Errorf("%v, add a wildcard DNS record *.%s", err, domain)
+	}
+	if !local {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("%s resolves to %s, not loopback", host, addr)
+		}
+	}
+	return nil
+}
+
+func checkListen(addr, flagName string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%v, stop what uses it or pick another %s", err, flagName)
+	}
+	return ln.Close()
+}
+
+func checkShell() error {
+	shell := "sh"
+	if runtime.GOOS == "windows" {
+		shell = "cmd"
+	}
+	if _, err := exec.LookPath(shell); err != nil {
+		return fmt.Errorf("%v, hooks, precondition: and release: need it", err)
+	}
+	return nil
+}
+
+func checkInotify() error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	if n < 65536 {
+		return fmt.Errorf("only %d, raise it with sysctl fs.inotify.max_user_watches=524288", n)
+	}
+	return nil
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".mux-doctor-*")
+	if os.IsNotExist(err) {
+		return fmt.Errorf("missing, create it or run with -init")
+	}
+	if err != nil {
+		return fmt.Errorf("%v, mux needs write access for .mux/ state", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	root := testRoot(t)
+	held, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	savedPort := port
+	port = strconv.Itoa(held.Addr().(*net.TCPAddr).Port)
+	defer func() { port = savedPort }()
+	missing := filepath.Join(root, "missing")
+	roots = []string{root, missing}
+	t.Setenv("PATH", "")
+
+	var ok bool
+	out := stdout(t, func() { ok = runDoctor() })
+	if ok {
+		t.Error("doctor passed with the port taken and a root missing")
+	}
+	for _, want := range []string{
+		"FAIL: port " + port + ": ",
+		"WARN: shell: ",
+		"OK: dir " + root + "\n",
+		"FAIL: dir " + missing + ": missing, create it or run with -init\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("doctor said %q, want %q", out, want)
+		}
+	}
+
+	held.Close()
+	roots = []string{root}
+	if out := stdout(t, func() { ok = runDoctor() }); !ok {
+		t.Errorf("doctor failed without critical problems: %q", out)
+	}
+}
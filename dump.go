@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	dumpApps = map[string]bool{}
+	dumpSize = 4096
+)
+
+type teeBody struct {
+	io.ReadCloser
+	label string
+	buf   bytes.Buffer
+	n     int
+	once  sync.Once
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if room := dumpSize - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	if err == io.EOF {
+		b.log()
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	b.log()
+	return b.ReadCloser.Close()
+}
+
+func (b *teeBody) log() {
+	b.once.Do(func() {
+		data := b.buf.Bytes()
+		switch {
+		case b.n == 0:
+			infof("DUMP: %s: empty", b.label)
+		case !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0:
+			infof("DUMP: %s: %d bytes of binary", b.label, b.n)
+		case b.n > len(data):
+			infof("DUMP: %s: %s... (%d bytes)", b.label, data, b.n)
+		default:
+			infof("DUMP: %s: %s", b.label, data)
+		}
+	})
+}
+
+func dumpBodies(name string, proxy *httputil.ReverseProxy) {
+	if !dumpApps[name] && !dumpApps["*"] {
+		return
+	}
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &teeBody{ReadCloser: r.Body, label: fmt.Sprintf("%s > %s %s", name, r.Method, r.URL.Path)}
+		}
+	}
+	modify := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if modify != nil {
+			if err := modify(resp); err != nil {
+				return err
+			}
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			resp.Body = &teeBody{ReadCloser: resp.Body, label: fmt.Sprintf("%s < %d %s", name, resp.StatusCode, resp.Request.URL.Path)}
+		}
+		return nil
+	}
+}
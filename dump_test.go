@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDumpBodies(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "dumped", "")
+	dumpApps["dumped"] = true
+	defer delete(dumpApps, "dumped")
+	buf := captureLog(t, levelInfo)
+
+	body := `{"name":"mux"}`
+	if w := upload("dumped.localhost", "/echo", body); w.Body.String() != body {
+		t.Fatalf("echoed %q, want %q", w.Body, body)
+	}
+	for _, want := range []string{
+		"DUMP: dumped > POST /echo: " + body + "\n",
+		"DUMP: dumped < 200 /echo: " + body + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q lacks %q", buf, want)
+		}
+	}
+}
+
+func TestTeeBody(t *testing.T) {
+	saved := dumpSize
+	dumpSize = 4
+	defer func() { dumpSize = saved }()
+	for body, want := range map[string]string{
+		"":          "DUMP: x: empty\n",
+		"abc":       "DUMP: x: abc\n",
+		"abcdefgh":  "DUMP: x: abcd... (8 bytes)\n",
+		"a\x00\x01": "DUMP: x: 3 bytes of binary\n",
+	} {
+		buf := captureLog(t, levelInfo)
+		tee := &teeBody{ReadCloser: io.NopCloser(strings.NewReader(body)), label: "x"}
+		data, _ := io.ReadAll(tee)
+		tee.Close()
+		if string(data) != body {
+			t.Errorf("read %q through the tee, want %q", data, body)
+		}
+		if got := buf.String(); !strings.HasSuffix(got, want) || strings.Count(got, "DUMP") != 1 {
+			t.Errorf("logged %q, want once %q", got, want)
+		}
+	}
+}
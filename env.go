@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With cleanEnv, apps get only the variables in envKeep from mux's own
+// environment, plus their .env files and what mux sets.
+var (
+	cleanEnv = false
+	envKeep  = "PATH,HOME,USER,LOGNAME,SHELL,LANG,LC_ALL,TZ,TERM,SystemRoot,ComSpec,PATHEXT,USERPROFILE,APPDATA,LOCALAPPDATA,TEMP,TMP"
+)
+
+// baseEnv is the part of mux's environment apps inherit.
+func baseEnv() []string {
+	if !cleanEnv {
+		return os.Environ()
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if k, _, _ := strings.Cut(kv, "="); keptVar(k) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// getenv is os.Getenv limited to what apps inherit.
+func getenv(k string) string {
+	if cleanEnv && !keptVar(k) {
+		return ""
+	}
+	return os.Getenv(k)
+}
+
+// keptVar ignores case, since Windows does.
+func keptVar(k string) bool {
+	for _, keep := range strings.Split(envKeep, ",") {
+		if strings.EqualFold(strings.TrimSpace(keep), k) {
+			return true
+		}
+	}
+	return false
+}
+
+// appEnv merges the .env files that apply to dir, later files overriding
+// earlier ones: the .env in its -dir root, the app's .env, then .env.local.
+func appEnv(dir string) (map[string]string, error) {
+	files := []string{filepath.Join(dir, ".env"), filepath.Join(dir, ".env.local")}
+	if root := rootOf(dir); root != "" && root != dir {
+		files = append([]string{filepath.Join(root, ".env")}, files...)
+	}
+	env := map[string]string{}
+	for _, file := range files {
+		if err := readDotEnv(file, env); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// startEnv is what everything run to start the app in dir sees: mux's
+// environment as -clean-env leaves it, the .env files, env-command: and
+// MUX_ENV. It also returns the app's own variables, for expanding commands.
+func startEnv(dir string, pf *procfile) (map[string]string, []string, error) {
+	dotEnv, err := appEnv(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pf.envCommand != "" {
+		if err := commandEnv(pf.envCommand, dir, dotEnv, pf.bootTimeout); err != nil {
+			return nil, nil, err
+		}
+	}
+	env := append(baseEnv(), envList(dotEnv)...)
+	if muxEnv != "" {
+		env = append(env, "MUX_ENV="+muxEnv)
+	}
+	return dotEnv, env, nil
+}
+
+func rootOf(dir string) string {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root
+		}
+	}
+	return ""
+}
+
+func readDotEnv(file string, env map[string]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parseDotEnv(f, file, env)
+}
+
+func parseDotEnv(r io.Reader, file string, env map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("BAD %s:%d: want KEY=VALUE", file, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	return scanner.Err()
+}
+
+// commandEnv adds the output of an env-command: to env, either KEY=VALUE
+// lines like a .env file or a JSON object of strings. It runs before every
+// start, so rotated secrets reach the next instance.
+func commandEnv(command, dir string, env map[string]string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Dir, cmd.Env, cmd.Stderr = dir, append(baseEnv(), envList(env)...), os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("ENV COMMAND %s: %v", command, err)
+	}
+	if trimmed := bytes.TrimSpace(out); bytes.HasPrefix(trimmed, []byte("{")) {
+		var vars map[string]string
+		if err := json.Unmarshal(trimmed, &vars); err != nil {
+			return fmt.Errorf("BAD env-command output: %v", err)
+		}
+		for k, v := range vars {
+			env[k] = v
+		}
+		return nil
+	}
+	return parseDotEnv(bytes.NewReader(out), "env-command", env)
+}
+
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
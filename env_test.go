@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMuxEnv(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "envs", "")
+	writeFiles(t, dir, map[string]string{"Procfile.staging": "web: " + testBin + " staging\n"})
+	muxEnv = "staging"
+	defer func() { muxEnv = "" }()
+
+	if got := procfilePath(dir); got != filepath.Join(dir, "Procfile.staging") {
+		t.Errorf("procfilePath = %s, want Procfile.staging", got)
+	}
+	if w := get("envs.localhost", "/env/MUX_ENV"); w.Body.String() != "staging" {
+		t.Errorf("MUX_ENV = %q, want staging", w.Body)
+	}
+	if w := get("envs.localhost", "/"); w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), "args=staging") {
+		t.Errorf("status %d: %s, want the Procfile.staging command", w.Code, w.Body)
+	}
+
+	muxEnv = "production"
+	if got := procfilePath(dir); got != filepath.Join(dir, "Procfile") {
+		t.Errorf("procfilePath without Procfile.production = %s, want Procfile", got)
+	}
+}
+
+func TestSharedDotEnv(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "layered", "")
+	writeFiles(t, root, map[string]string{".env": "SHARED=root\nLEVEL=root\nLOCAL=root\n"})
+	writeFiles(t, dir, map[string]string{
+		".env":       "MUX_TEST_BACKEND=1\nLEVEL=app\nLOCAL=app\n",
+		".env.local": "LOCAL=local\n",
+	})
+	for key, want := range map[string]string{"SHARED": "root", "LEVEL": "app", "LOCAL": "local"} {
+		if w := get("layered.localhost", "/env/"+key); w.Body.String() != want {
+			t.Errorf("%s = %q, want %q", key, w.Body, want)
+		}
+	}
+}
+
+func TestCleanEnv(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "isolated", "")
+	t.Setenv("MUX_SECRET", "leaked")
+	t.Setenv("MUX_KEPT", "kept")
+	savedClean, savedKeep := cleanEnv, envKeep
+	cleanEnv, envKeep = true, "PATH, mux_kept"
+	defer func() { cleanEnv, envKeep = savedClean, savedKeep }()
+
+	for key, want := range map[string]string{"MUX_SECRET": "", "MUX_KEPT": "kept", "MUX_TEST_BACKEND": "1"} {
+		if w := get("isolated.localhost", "/env/"+key); w.Body.String() != want {
+			t.Errorf("%s = %q, want %q", key, w.Body, want)
+		}
+	}
+	if w := get("isolated.localhost", "/env/PORT"); w.Body.String() == "" {
+		t.Error("PORT not set with -clean-env")
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	dir := testApp(t, root, "vaulted", "env-command: cat secret\n")
+	writeFiles(t, dir, map[string]string{"secret": "SECRET=one\n"})
+	if w := get("vaulted.localhost", "/env/SECRET"); w.Body.String() != "one" {
+		t.Errorf("SECRET = %q, want one from KEY=VALUE output", w.Body)
+	}
+
+	writeFiles(t, dir, map[string]string{"secret": `{"SECRET": "two"}`})
+	mu.Lock()
+	app := apps["vaulted"]
+	mu.Unlock()
+	stopApp(app)
+	<-app.exited
+	if w := get("vaulted.localhost", "/env/SECRET"); w.Body.String() != "two" {
+		t.Errorf("SECRET after a restart = %q, want two from JSON output", w.Body)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.App}}</title></head>
+<body style="font-family: sans-serif; margin: 3em">
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p><b>{{.App}}</b></p>
+<pre>{{.Error}}</pre>
+</body>
+</html>
+`))
+
+type errorPage struct {
+	App        string
+	Status     int
+	StatusText string
+	Error      string
+}
+
+func errorTemplate(dir string) *template.Template {
+	file := filepath.Join(dir, ".mux", "error.html.tmpl")
+	if _, err := os.Stat(file); err != nil {
+		return defaultErrorPage
+	}
+	t, err := template.ParseFiles(file)
+	if err != nil {
+		warnf("ERROR PAGE: %v", err)
+		return defaultErrorPage
+	}
+	return t
+}
+
+func appError(w http.ResponseWriter, r *http.Request, name, dir string, status int, err error) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	page := errorPage{name, status, http.StatusText(status), err.Error()}
+	var buf bytes.Buffer
+	if terr := errorTemplate(dir).Execute(&buf, page); terr != nil {
+		warnf("ERROR PAGE: %s: %v", name, terr)
+		buf.Reset()
+		_ = defaultErrorPage.Execute(&buf, page)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// retryAfter is the Retry-After for errors from an app that is down or slow:
+// its retry-after:, else its boot-timeout, the longest a restart should take.
+func retryAfter(pf *procfile) string {
+	d := pf.retryAfter
+	if d == 0 {
+		d = pf.bootTimeout
+	}
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+func errorStatus(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return http.StatusInternalServerError
+}
+
+func proxyErrorStatus(err error) int {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
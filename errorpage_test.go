@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getHTML is get from a browser.
+func getHTML(host, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://"+host+path, nil)
+	r.Header.Set("Accept", "text/html,*/*")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestErrorTemplate(t *testing.T) {
+	root := testRoot(t)
+	for _, name := range []string{"custom", "plain", "broken"} {
+		dir := testApp(t, root, name, "")
+		writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=exit\n"})
+	}
+	writeFiles(t, root, map[string]string{
+		"custom/.mux/error.html.tmpl": "<p>{{.App}} failed with {{.Status}}: {{.Error}}</p>",
+		"broken/.mux/error.html.tmpl": "<p>{{.App</p>",
+	})
+
+	w := getHTML("custom.localhost", "/")
+	if w.Code != http.StatusInternalServerError || !strings.HasPrefix(w.Body.String(), "<p>custom failed with 500: EXITED before listening") {
+		t.Errorf("custom page: %d %s", w.Code, w.Body)
+	}
+	for _, name := range []string{"plain", "broken"} {
+		w := getHTML(name+".localhost", "/")
+		if !strings.Contains(w.Body.String(), "<h1>500 Internal Server Error</h1>") {
+			t.Errorf("%s: want the built-in page, got %d %s", name, w.Code, w.Body)
+		}
+	}
+	if w := get("custom.localhost", "/"); !strings.HasPrefix(w.Body.String(), "EXITED before listening") {
+		t.Errorf("without Accept: text/html got %q, want plain text", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	appError(w, httptest.NewRequest("GET", "/", nil), "custom", root+"/custom", http.StatusBadGateway, errors.New("<script>"))
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("non-browser got %s", w.Header().Get("Content-Type"))
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	appError(w, r, "custom", root+"/custom", http.StatusBadGateway, errors.New("<script>"))
+	if got := w.Body.String(); got != "<p>custom failed with 502: &lt;script&gt;</p>" {
+		t.Errorf("page %q, want the error escaped", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "quick", "retry-after: 2.5s\n")
+	testApp(t, root, "slow", "boot-timeout: 20s\n")
+	for name, want := range map[string]string{"quick": "3", "slow": "20"} {
+		w := get(name+".localhost", "/reset")
+		if w.Code != http.StatusBadGateway || w.Header().Get("Retry-After") != want {
+			t.Errorf("%s: %d with Retry-After %q, want 502 and %s", name, w.Code, w.Header().Get("Retry-After"), want)
+		}
+	}
+}
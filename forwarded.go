@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	trustedProxies []netip.Prefix
+	allowedHosts   []string
+	allowedIPs     []netip.Prefix
+	deniedIPs      []netip.Prefix
+)
+
+// parseHosts returns the Host patterns mux answers to: localhost, -host and
+// their subdomains, plus the names or *.suffix patterns in spec. A single *
+// allows any host.
+func parseHosts(spec string) []string {
+	hosts := []string{"localhost", "*.localhost", domain, "*." + domain}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			hosts = append(hosts, item)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed guards against DNS rebinding, where a page on a public name
+// that resolves to 127.0.0.1 would otherwise reach local apps.
+func hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range allowedHosts {
+		if pattern == "*" || pattern == host || strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePrefixes(flagName, spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("BAD -%s %q, want an IP or CIDR", flagName, item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAllowed checks the connecting address against -deny-ip, then
+// -allow-ip if it is set. Like from in allow: rules it ignores
+// X-Forwarded-For, which any client can send.
+func clientAllowed(r *http.Request) bool {
+	if allowedIPs == nil && deniedIPs == nil {
+		return true
+	}
+	addr, ok := remoteAddr(r)
+	if !ok || containsAddr(deniedIPs, addr) {
+		return false
+	}
+	return allowedIPs == nil || containsAddr(allowedIPs, addr)
+}
+
+func fromTrustedProxy(r *http.Request) bool {
+	addr, ok := remoteAddr(r)
+	return ok && containsAddr(trustedProxies, addr)
+}
+
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+func requestHost(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+func requestScheme(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto != "" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func setForwarded(r *http.Request) {
+	host, scheme := requestHost(r), requestScheme(r)
+	if !fromTrustedProxy(r) {
+		r.Header.Del("X-Forwarded-For")
+	}
+	r.Header.Set("X-Forwarded-Host", host)
+	r.Header.Set("X-Forwarded-Proto", scheme)
+	setClientCert(r)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// forwarded sends a request for path from remote through handler, with
+// X-Forwarded-* headers naming host and proto.
+func forwarded(remote, host, path, proto string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://proxy.internal"+path, nil)
+	r.RemoteAddr = remote + ":40000"
+	r.Header.Set("X-Forwarded-Host", host)
+	r.Header.Set("X-Forwarded-Proto", proto)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestTrustedProxy(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "blog", "")
+	var err error
+	if trustedProxies, err = parsePrefixes("trusted-proxy", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { trustedProxies = nil }()
+
+	for header, want := range map[string]string{
+		"X-Forwarded-Host":  "blog.localhost",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-For":   "203.0.113.7, 10.1.2.3",
+	} {
+		if w := forwarded("10.1.2.3", "blog.localhost", "/header/"+header, "https"); w.Body.String() != want {
+			t.Errorf("from the proxy the app got %s %q, want %q", header, w.Body, want)
+		}
+	}
+
+	if w := forwarded("192.0.2.1", "blog.localhost", "/", "https"); w.Code != http.StatusMisdirectedRequest {
+		t.Errorf("untrusted X-Forwarded-Host routed: %d %s", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-For":   "192.0.2.1",
+	} {
+		r := httptest.NewRequest("GET", "http://blog.localhost/header/"+header, nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Body.String() != want {
+			t.Errorf("from a client the app got %s %q, want %q", header, w.Body, want)
+		}
+	}
+}
+
+func TestAllowHost(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	allowedHosts = parseHosts("dev.test, *.preview.test")
+	for host, want := range map[string]int{
+		"api.localhost":          http.StatusOK,
+		"api.dev.test":           http.StatusMisdirectedRequest,
+		"dev.test":               http.StatusNotFound,
+		"api.preview.test":       http.StatusNotFound,
+		"evil.com":               http.StatusMisdirectedRequest,
+		"api.localhost.evil.com": http.StatusMisdirectedRequest,
+	} {
+		if w := get(host, "/"); w.Code != want {
+			t.Errorf("%s: status %d, want %d", host, w.Code, want)
+		}
+	}
+
+	for _, c := range []struct {
+		host, method string
+		header       bool
+		want         int
+	}{
+		{"evil.com", "GET", false, http.StatusMisdirectedRequest},
+		{"127.0.0.1:7070", "GET", false, http.StatusOK},
+		{"localhost", "POST", false, http.StatusForbidden},
+		{"localhost", "POST", true, http.StatusOK},
+	} {
+		r := httptest.NewRequest(c.method, "http://"+c.host+"/apps/stop", nil)
+		if c.method == "GET" {
+			r.URL.Path = "/apps"
+		}
+		if c.header {
+			r.Header.Set(adminHeader, "1")
+		}
+		w := httptest.NewRecorder()
+		adminMux(false).ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("admin %s with Host %s: status %d, want %d", c.method, c.host, w.Code, c.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{"lan/index.html": "lan"})
+	var err error
+	if allowedIPs, err = parsePrefixes("allow-ip", "192.168.1.0/24, ::1"); err != nil {
+		t.Fatal(err)
+	}
+	if deniedIPs, err = parsePrefixes("deny-ip", "192.168.1.66"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { allowedIPs, deniedIPs = nil, nil }()
+
+	for remote, want := range map[string]int{
+		"192.168.1.10:40000":          http.StatusOK,
+		"[::ffff:192.168.1.10]:40000": http.StatusOK,
+		"[::1]:40000":                 http.StatusOK,
+		"192.168.1.66:40000":          http.StatusForbidden,
+		"192.168.2.10:40000":          http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "http://lan.localhost/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", "192.168.1.10")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != want {
+			t.Errorf("from %s: %d, want %d", remote, w.Code, want)
+		}
+	}
+	if _, err := parsePrefixes("allow-ip", "192.168.1.0/33"); err == nil {
+		t.Error("parsePrefixes accepted a bad CIDR")
+	}
+}
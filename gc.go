@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultRetention = 7 * 24 * time.Hour
+
+// gcHandler cleans the .mux directories of all apps. Apps not running lose
+// their runtime.json, pid, activity and temp dirs; running ones only temp
+// dirs of earlier instances older than ?older-than, like *.log files of any
+// app. An app whose runtime.json names a live process counts as running,
+// since mux adopts it on the next request.
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	retention := defaultRetention
+	if s := r.URL.Query().Get("older-than"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeAdminError(w, http.StatusBadRequest, "bad_request", "BAD older-than "+s)
+			return
+		}
+		retention = d
+	}
+	names, _, err := scanApps()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	cutoff := time.Now().Add(-retention)
+	for _, name := range names {
+		dir, err := appDir(name)
+		if err != nil {
+			continue
+		}
+		mu.Lock()
+		a, running := apps[name]
+		_, booting := starting[name]
+		mu.Unlock()
+		if booting {
+			continue
+		}
+		keepTmp := ""
+		if running {
+			keepTmp = a.tmp
+		} else if adoptable(dir) {
+			running = true
+		}
+		for _, file := range staleArtifacts(dir, running, keepTmp, cutoff) {
+			if err := os.RemoveAll(file); err != nil {
+				warnf("GC: %s: %v", name, err)
+				continue
+			}
+			rel, _ := filepath.Rel(dir, file)
+			fmt.Fprintf(w, "%s: removed %s\n", name, rel)
+		}
+	}
+}
+
+// adoptable reports whether dir's runtime.json names a live process.
+func adoptable(dir string) bool {
+	rt, err := readRuntime(dir)
+	if err != nil {
+		return false
+	}
+	_, err = findProcess(rt.PID)
+	return err == nil
+}
+
+func staleArtifacts(dir string, running bool, keepTmp string, cutoff time.Time) []string {
+	var stale []string
+	old := func(file string) bool {
+		fi, err := os.Stat(file)
+		return err == nil && fi.ModTime().Before(cutoff)
+	}
+	base := filepath.Join(dir, ".mux")
+	if !running {
+		for _, file := range []string{runtimeFile(dir), pidFile(dir), activityFile(dir)} {
+			if _, err := os.Stat(file); err == nil {
+				stale = append(stale, file)
+			}
+		}
+	}
+	tmps, _ := os.ReadDir(filepath.Join(base, "tmp"))
+	for _, e := range tmps {
+		file := filepath.Join(base, "tmp", e.Name())
+		if !running || file != keepTmp && old(file) {
+			stale = append(stale, file)
+		}
+	}
+	entries, _ := os.ReadDir(base)
+	for _, e := range entries {
+		file := filepath.Join(base, e.Name())
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") && old(file) {
+			stale = append(stale, file)
+		}
+	}
+	return stale
+}
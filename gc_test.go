@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	root := testRoot(t)
+	stopped := testApp(t, root, "stopped", "")
+	live := testApp(t, root, "live", "")
+	get("live.localhost", "/")
+	mu.Lock()
+	keepTmp := apps["live"].tmp
+	mu.Unlock()
+
+	long := time.Now().Add(-48 * time.Hour)
+	for _, dir := range []string{stopped, live} {
+		writeFiles(t, filepath.Join(dir, ".mux"), map[string]string{
+			"old.log":     "old",
+			"new.log":     "new",
+			"tmp/old/a":   "a",
+			"tmp/fresh/a": "a",
+		})
+		for _, file := range []string{"old.log", "tmp/old"} {
+			if err := os.Chtimes(filepath.Join(dir, ".mux", file), long, long); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFiles(t, filepath.Join(stopped, ".mux"), map[string]string{"runtime.json": "from a crash", "pid": "1\n"})
+
+	w := admin("POST", "/gc?older-than=24h", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("gc: %d %s", w.Code, w.Body)
+	}
+	for file, want := range map[string]bool{
+		runtimeFile(stopped):                           false,
+		pidFile(stopped):                               false,
+		filepath.Join(stopped, ".mux", "old.log"):      false,
+		filepath.Join(stopped, ".mux", "new.log"):      true,
+		filepath.Join(stopped, ".mux", "tmp", "fresh"): false,
+		runtimeFile(live):                              true,
+		pidFile(live):                                  true,
+		filepath.Join(live, ".mux", "old.log"):         false,
+		filepath.Join(live, ".mux", "new.log"):         true,
+		filepath.Join(live, ".mux", "tmp", "old"):      false,
+		filepath.Join(live, ".mux", "tmp", "fresh"):    true,
+	} {
+		if _, err := os.Stat(file); (err == nil) != want {
+			rel, _ := filepath.Rel(root, file)
+			t.Errorf("%s kept %v, want %v", rel, err == nil, want)
+		}
+	}
+	if keepTmp != "" && !isDir(keepTmp) {
+		t.Errorf("removed the running instance's temp dir %s", keepTmp)
+	}
+	if !strings.Contains(w.Body.String(), "stopped: removed "+filepath.Join(".mux", "runtime.json")) {
+		t.Errorf("gc listed %q", w.Body)
+	}
+	if w := admin("POST", "/gc?older-than=soon", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("older-than=soon: %d", w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const maxHashes = 4096
+
+var (
+	hashCheck = false
+	hashMu    sync.Mutex
+	hashes    = map[string][sha256.Size]byte{}
+)
+
+// unchanged reports whether path has the content it had the last time it
+// was seen, so editors rewriting a file or touch don't reload the app.
+func unchanged(path string) bool {
+	sum, ok := fileHash(path)
+	if !ok {
+		hashMu.Lock()
+		delete(hashes, path)
+		hashMu.Unlock()
+		return false
+	}
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	prev, seen := hashes[path]
+	storeHash(path, sum)
+	return seen && prev == sum
+}
+
+// seedHashes records the watched files of app, and the files .watch names
+// exactly, as they are when it starts, so the first event for one that did
+// not change is ignored too.
+func seedHashes(app *appInfo, files []string) {
+	for _, file := range files {
+		path := filepath.Join(app.dir, file)
+		if sum, ok := fileHash(path); ok {
+			hashMu.Lock()
+			storeHash(path, sum)
+			hashMu.Unlock()
+		}
+	}
+	_ = filepath.WalkDir(app.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != app.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(app.dir, path)
+		if !matchInverted(rel, app.ig) {
+			return nil
+		}
+		if sum, ok := fileHash(path); ok {
+			hashMu.Lock()
+			storeHash(path, sum)
+			hashMu.Unlock()
+		}
+		return nil
+	})
+}
+
+// storeHash remembers sum for path; hashMu must be held.
+func storeHash(path string, sum [sha256.Size]byte) {
+	if _, seen := hashes[path]; !seen && len(hashes) >= maxHashes {
+		clear(hashes)
+	}
+	hashes[path] = sum
+}
+
+func fileHash(path string) (sum [sha256.Size]byte, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return sum, false
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, false
+	}
+	h.Sum(sum[:0])
+	return sum, true
+}
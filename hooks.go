@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+var hookEvents = []string{"start", "stop", "reload", "crash"}
+
+var hookVerbs = map[string]string{
+	"start":  "has started",
+	"stop":   "is stopped",
+	"reload": "reloads on a file change",
+	"crash":  "exits on its own",
+}
+
+var globalHooks = map[string]string{}
+
+func runHook(app *appInfo, event string) {
+	command := app.pf.hooks[event]
+	if command == "" {
+		command = globalHooks[event]
+	}
+	if command == "" {
+		return
+	}
+	env := app.env
+	if env == nil {
+		// Adopted apps were started by an earlier mux.
+		dotEnv, _ := appEnv(app.dir)
+		env = append(baseEnv(), envList(dotEnv)...)
+	}
+	cmd := shellCommand(context.Background(), command)
+	cmd.Dir = app.dir
+	cmd.Env = append(env[:len(env):len(env)],
+		"MUX_APP="+app.name,
+		"MUX_EVENT="+event,
+		"MUX_DIR="+app.dir,
+		fmt.Sprintf("MUX_PORT=%d", app.port),
+	)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	go func() {
+		if err := cmd.Run(); err != nil {
+			warnf("HOOK: %s on-%s: %v", app.name, event, err)
+		}
+	}()
+}
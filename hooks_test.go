@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	events := filepath.Join(t.TempDir(), "events")
+	hook := `echo "$MUX_EVENT $MUX_APP $MUX_PORT" >> ` + events
+	testApp(t, root, "hooked", "on-start: "+hook+"\non-stop: "+hook+"\non-reload: exit 3\n")
+	globalHooks["crash"] = hook
+	defer delete(globalHooks, "crash")
+	logged := func(want string) {
+		t.Helper()
+		waitFor(t, want, func() bool {
+			data, _ := os.ReadFile(events)
+			return strings.Contains(string(data), want+"\n")
+		})
+	}
+
+	if w := get("hooked.localhost", "/"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	app := apps["hooked"]
+	mu.Unlock()
+	logged(fmt.Sprintf("start hooked %d", app.port))
+
+	// A failing hook leaves the app alone.
+	buf := captureLog(t, levelWarn)
+	runHook(app, "reload")
+	waitFor(t, "the hook warning", func() bool { return strings.Contains(buf.String(), "HOOK: hooked on-reload: exit status 3") })
+	if w := get("hooked.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("after a failed hook: status %d: %s", w.Code, w.Body)
+	}
+
+	stopApp(app)
+	<-app.exited
+	logged(fmt.Sprintf("stop hooked %d", app.port))
+
+	get("hooked.localhost", "/")
+	mu.Lock()
+	app = apps["hooked"]
+	mu.Unlock()
+	if err := app.c.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	logged(fmt.Sprintf("crash hooked %d", app.port))
+	// watchExit stops the crashed app too; let it finish before the test
+	// restores what it reads.
+	waitFor(t, "the crashed app to stop", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["hooked"] != app
+	})
+}
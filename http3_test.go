@@ -0,0 +1,111 @@
+//go:build http3test
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// selfSigned returns a certificate for localhost and a pool trusting it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// freeUDPPort returns a UDP port nothing listens on right now.
+func freeUDPPort(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return strconv.Itoa(c.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestServeHTTP3(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "quic", "")
+	cert, pool := selfSigned(t)
+	tlsPort = freeUDPPort(t)
+	h := serveHTTP3(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "localhost:"+tlsPort {
+			io.WriteString(w, r.Proto)
+			return
+		}
+		handler(w, r)
+	}), &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	rt := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer rt.Close()
+	client := &http.Client{Transport: rt, Timeout: 2 * time.Second}
+	var resp *http.Response
+	var err error
+	for range 20 {
+		if resp, err = client.Get("https://localhost:" + tlsPort + "/"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "HTTP/3.0" {
+		t.Errorf("body = %q, want HTTP/3.0", body)
+	}
+
+	// Backends still speak HTTP/1.1 to mux.
+	req, _ := http.NewRequest("GET", "https://localhost:"+tlsPort+"/", nil)
+	req.Host = "quic.localhost"
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "PORT=") {
+		t.Errorf("proxied over HTTP/3: status %d: %s", resp.StatusCode, body)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("Alt-Svc") == "" {
+		t.Errorf("Alt-Svc not advertised over TCP")
+	}
+}
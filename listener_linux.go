@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listenerPID finds the process listening on the local TCP port through
+// /proc, which tells the server apart from a start script that runs it
+// without exec. It returns 0 if it can't tell.
+func listenerPID(port int) int {
+	inodes := map[string]bool{}
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			// sl local_address rem_address st ... inode; st 0A is LISTEN.
+			if len(fields) < 10 || fields[3] != "0A" || !strings.HasSuffix(fields[1], fmt.Sprintf(":%04X", port)) {
+				continue
+			}
+			inodes["socket:["+fields[9]+"]"] = true
+		}
+		f.Close()
+	}
+	if len(inodes) == 0 {
+		return 0
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if link, err := os.Readlink(fd); err == nil && inodes[link] {
+			pid, _ := strconv.Atoi(strings.Split(fd, "/")[2])
+			return pid
+		}
+	}
+	return 0
+}
+
+// ownsPort reports whether the process listening on port is pid or in its
+// process group or session, or whether it can't tell.
+func ownsPort(pid, port int) bool {
+	lp := listenerPID(port)
+	if lp == 0 || lp == pid {
+		return true
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", lp))
+	if err != nil {
+		return true
+	}
+	// pid (comm) state ppid pgrp session ...; comm may contain spaces.
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	if len(fields) < 4 {
+		return true
+	}
+	return fields[2] == strconv.Itoa(pid) || fields[3] == strconv.Itoa(pid)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// listenerPID is only known on Linux; elsewhere status shows the PID mux
+// started.
+func listenerPID(port int) int { return 0 }
+
+func ownsPort(pid, port int) bool { return true }
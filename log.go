@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelQuiet logLevel = iota - 1
+	levelError
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+var level = levelInfo
+
+var logJSON = false
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if name == s {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("BAD -log-level %q: want error, warn, info or debug", s)
+}
+
+func logf(l logLevel, format string, args ...any) {
+	if l > level {
+		return
+	}
+	if !logJSON {
+		log.Printf(format, args...)
+		return
+	}
+	entry := logEntry{Level: logLevelNames[l], Msg: fmt.Sprintf(format, args...)}
+	// Messages follow "EVENT: app ...", which gives the event and app fields.
+	if event, rest, ok := strings.Cut(format, ": "); ok && isEvent(event) {
+		entry.Event = strings.ToLower(event)
+		if app, ok := firstArg(args); ok && strings.HasPrefix(rest, "%s") && validName(app) && !strings.Contains(app, " ") {
+			entry.App = app
+		}
+	}
+	writeJSON(entry)
+}
+
+func isEvent(s string) bool {
+	return s != "" && strings.Trim(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ ") == ""
+}
+
+func firstArg(args []any) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	s, ok := args[0].(string)
+	return s, ok
+}
+
+type logEntry struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	App   string    `json:"app,omitempty"`
+	Event string    `json:"event,omitempty"`
+	Msg   string    `json:"msg"`
+}
+
+// jsonOut is where -log-json entries go; jsonMu guards it.
+var (
+	jsonMu  sync.Mutex
+	jsonOut io.Writer = os.Stderr
+)
+
+func writeJSON(entry logEntry) {
+	entry.Time = time.Now()
+	data, _ := json.Marshal(entry)
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	_, _ = jsonOut.Write(append(data, '\n'))
+}
+
+// jsonWriter turns what still goes through the log package, like log.Fatal,
+// into error entries.
+type jsonWriter struct{}
+
+func (jsonWriter) Write(p []byte) (int, error) {
+	writeJSON(logEntry{Level: "error", Msg: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+func setLogJSON() {
+	logJSON = true
+	log.SetFlags(0)
+	log.SetOutput(jsonWriter{})
+}
+
+func debugf(format string, args ...any) { logf(levelDebug, format, args...) }
+func infof(format string, args ...any)  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...any) { logf(levelError, format, args...) }
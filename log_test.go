@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logBuffer collects log output that goroutines of the test still write.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *logBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// captureLog returns what logging through the log package writes until the
+// test ends, logging at l.
+func captureLog(t *testing.T, l logLevel) *logBuffer {
+	t.Helper()
+	buf := &logBuffer{}
+	saved := level
+	level = l
+	log.SetOutput(buf)
+	t.Cleanup(func() {
+		level = saved
+		log.SetOutput(os.Stderr)
+	})
+	return buf
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, tt := range []struct {
+		level logLevel
+		want  []string
+	}{
+		{levelQuiet, nil},
+		{levelError, []string{"ERROR"}},
+		{levelWarn, []string{"ERROR", "WARN"}},
+		{levelInfo, []string{"ERROR", "WARN", "INFO"}},
+		{levelDebug, []string{"ERROR", "WARN", "INFO", "DEBUG"}},
+	} {
+		buf := captureLog(t, tt.level)
+		errorf("ERROR: x")
+		warnf("WARN: x")
+		infof("INFO: x")
+		debugf("DEBUG: x")
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if event, _, ok := strings.Cut(line, ": x"); ok {
+				got = append(got, event[strings.LastIndex(event, " ")+1:])
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("level %d logged %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for s, want := range map[string]logLevel{"error": levelError, "warn": levelWarn, "info": levelInfo, "debug": levelDebug} {
+		if got, err := parseLogLevel(s); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel accepted verbose")
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	buf := &logBuffer{}
+	jsonMu.Lock()
+	jsonOut = buf
+	jsonMu.Unlock()
+	savedLevel := level
+	level = levelDebug
+	setLogJSON()
+	defer func() {
+		jsonMu.Lock()
+		jsonOut = os.Stderr
+		jsonMu.Unlock()
+		level, logJSON = savedLevel, false
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}()
+
+	get("api.localhost", "/")
+	log.Print("fatal: from the log package")
+	var start, fatal *logEntry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		if entry.Time.IsZero() || entry.Level == "" || entry.Msg == "" {
+			t.Errorf("line %q misses time, level or msg", line)
+		}
+		switch {
+		case entry.Event == "start":
+			start = &entry
+		case strings.HasPrefix(entry.Msg, "fatal: "):
+			fatal = &entry
+		}
+	}
+	if start == nil || start.App != "api" || start.Level != "debug" || !strings.HasPrefix(start.Msg, "START: api ") {
+		t.Errorf("start entry = %+v, want app api at debug", start)
+	}
+	if fatal == nil || fatal.Level != "error" || fatal.Event != "" {
+		t.Errorf("log package entry = %+v, want an error without event", fatal)
+	}
+}
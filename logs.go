@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var logLines = 0
+
+type logRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, size)}
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.lines[r.next] = string(r.partial[:i])
+		r.next = (r.next + 1) % len(r.lines)
+		r.full = r.full || r.next == 0
+		r.partial = r.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (r *logRing) tail(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	if r.full {
+		out = append(out, r.lines[r.next:]...)
+	}
+	out = append(out, r.lines[:r.next]...)
+	if n >= 0 && n < len(out) {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	tail := -1
+	if s := r.URL.Query().Get("tail"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, "bad_request", "BAD tail "+s)
+			return
+		}
+		tail = n
+	}
+	a, ok := runningApp(w, r)
+	if !ok {
+		return
+	}
+	name := a.name
+	if a.logs == nil {
+		writeAdminError(w, http.StatusNotFound, "no_logs", "NOT capturing logs, run mux with -log-lines")
+		return
+	}
+	lines := a.logs.tail(tail)
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"app": name, "lines": lines})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		_, _ = w.Write([]byte(line + "\n"))
+	}
+}
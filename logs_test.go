@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLogRing(t *testing.T) {
+	r := newLogRing(3)
+	r.Write([]byte("one\ntwo\nthr"))
+	if got := r.tail(-1); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("tail = %q, want complete lines only", got)
+	}
+	r.Write([]byte("ee\nfour\n"))
+	if got := r.tail(-1); !slices.Equal(got, []string{"two", "three", "four"}) {
+		t.Errorf("tail = %q after wrapping", got)
+	}
+	if got := r.tail(1); !slices.Equal(got, []string{"four"}) {
+		t.Errorf("tail(1) = %q", got)
+	}
+}
+
+func TestLogsHandler(t *testing.T) {
+	root := testRoot(t)
+	logLines = 10
+	defer func() { logLines = 0 }()
+	testApp(t, root, "chatty", "")
+	testApp(t, root, "quiet", "")
+	for _, line := range []string{"one", "two", "three"} {
+		get("chatty.localhost", "/print/"+line)
+	}
+	mu.Lock()
+	app := apps["chatty"]
+	mu.Unlock()
+	waitFor(t, "the output", func() bool { return len(app.logs.tail(-1)) == 3 })
+
+	w := admin("GET", "/apps/chatty/logs?tail=2", "")
+	if w.Code != http.StatusOK || w.Body.String() != "two\nthree\n" {
+		t.Errorf("plain logs: %d %q", w.Code, w.Body)
+	}
+	w = admin("GET", "/apps/chatty/logs?format=json", "")
+	var logs struct {
+		App   string
+		Lines []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil || logs.App != "chatty" || !slices.Equal(logs.Lines, []string{"one", "two", "three"}) {
+		t.Errorf("JSON logs: %v %s", err, w.Body)
+	}
+	if w := admin("GET", "/apps/chatty/logs?tail=-1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("tail=-1: %d %s", w.Code, w.Body)
+	}
+	if w := admin("GET", "/apps/quiet/logs", ""); w.Code != http.StatusNotFound || adminCode(w) != "not_running" {
+		t.Errorf("stopped app: %d %s", w.Code, w.Body)
+	}
+	if w := admin("GET", "/apps/missing/logs", ""); w.Code != http.StatusNotFound || adminCode(w) != "unknown_app" {
+		t.Errorf("unknown app: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLogsCommand(t *testing.T) {
+	root := testRoot(t)
+	logLines = 10
+	defer func() { logLines = 0 }()
+	testApp(t, root, "chatty", "")
+	get("chatty.localhost", "/print/hello")
+	mu.Lock()
+	app := apps["chatty"]
+	mu.Unlock()
+	waitFor(t, "the output", func() bool { return len(app.logs.tail(-1)) == 1 })
+	testAdminSocket(t)
+
+	var err error
+	out := stdout(t, func() { err = runLogs([]string{"chatty"}) })
+	if err != nil || out != "hello\n" {
+		t.Fatalf("mux -logs: %v %q", err, out)
+	}
+	if err := runLogs(nil); err == nil || !strings.Contains(err.Error(), "USAGE") {
+		t.Errorf("mux -logs without an app: %v", err)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -13,231 +17,1039 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/kardianos/service"
 	ignore "github.com/sabhiram/go-gitignore"
 )
 
 var (
-	apps    = map[string]*appInfo{}
-	mu      sync.Mutex
-	root    = ""
-	domain  = ""
-	port    = ""
-	idleTTL = 10 * time.Minute
-	verbose = false
+	apps      = map[string]*appInfo{}
+	failures  = map[string]time.Time{}
+	overrides = map[string]string{}
+	starting  = map[string]*startCall{}
+	startSem  chan struct{}
+	ttyApps   = map[string]bool{}
+	mu        sync.Mutex
+	roots     []string
+	domain    = ""
+	port      = ""
+	idleTTL   = 10 * time.Minute
+
+	idleStrategy = "time"
+	noIdle       = false
+	asyncStart   = false
+	foreground   = false
+
+	runAsUser   = ""
+	defaultNice = 0
+	dirTemplate = "{app}"
+	muxEnv      = ""
+	maxBody     = int64(32 << 20)
+	portLow     = 0
+	portHigh    = 0
+
+	startRetries = 0
 )
 
 type appInfo struct {
-	name    string
-	dir     string
-	p       *httputil.ReverseProxy
-	c       *exec.Cmd
-	t       time.Time
-	watcher *fsnotify.Watcher
-	ig      *ignore.GitIgnore
+	name string
+	dir  string
+	p    *httputil.ReverseProxy
+	tr   *http.Transport
+	c    *exec.Cmd
+	t    time.Time
+	host string
+	port int
+	logs *logRing
+	ig   *ignore.GitIgnore
+	pf   *procfile
+	tmp  string
+	env  []string
+
+	// serverPID is the process listening on port, if savePID found it.
+	serverPID int
+
+	active atomic.Int64
+	// streams counts open -tcp connections, which keep the app from idling
+	// with either -idle-strategy: a client like a database pool may hold
+	// one open without sending anything for longer than the idle TTL.
+	streams atomic.Int64
+
+	exited    chan struct{}
+	stopping  bool
+	crashed   bool
+	reloading bool
+	degraded  string
+	dirty     string
+	idle      time.Duration
+
+	bufferRequest bool
 }
 
-const debounceDelay = 1000 * time.Millisecond
+const (
+	debounceDelay       = 100 * time.Millisecond
+	maxIdleConnsPerHost = 8
+	idleConnTimeout     = 30 * time.Second
 
-func freePort() int {
-	l, _ := net.Listen("tcp", "127.0.0.1:0")
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port
+	// minRetryDelay is the first delay between -start-retries attempts when
+	// the Procfile sets a shorter backoff: or none. It doubles on each retry.
+	minRetryDelay = 250 * time.Millisecond
+)
+
+// claimed holds ports handed to apps that are still booting, so two apps
+// starting at once don't get the same one while neither listens yet.
+var (
+	claimedMu sync.Mutex
+	claimed   = map[int]bool{}
+)
+
+// errPortTaken means an app exited without listening while something else
+// took its port in the meantime, so it is worth starting again on another.
+var errPortTaken = errors.New("PORT TAKEN")
+
+func claimPort(p int) bool {
+	claimedMu.Lock()
+	defer claimedMu.Unlock()
+	if claimed[p] {
+		return false
+	}
+	claimed[p] = true
+	return true
 }
 
-func waitPort(port int, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	for time.Now().Before(deadline) {
-		conn, err := net.DialTimeout("tcp", addr, 200*time.Millisecond)
+func unclaimPort(p int) {
+	claimedMu.Lock()
+	delete(claimed, p)
+	claimedMu.Unlock()
+}
+
+// freePort finds and claims a port nothing listens on; unclaimPort gives it
+// back once the app listens or failed.
+func freePort() (int, error) {
+	if portLow == 0 {
+		for range 10 {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return 0, err
+			}
+			p := l.Addr().(*net.TCPAddr).Port
+			l.Close()
+			if claimPort(p) {
+				return p, nil
+			}
+		}
+		return 0, fmt.Errorf("NO free port")
+	}
+	n := portHigh - portLow + 1
+	offset := rand.IntN(n)
+	for i := range n {
+		p := portLow + (offset+i)%n
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+		if err == nil {
+			l.Close()
+			if claimPort(p) {
+				return p, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("NO free port in -port-range %d-%d", portLow, portHigh)
+}
+
+// loopbacks are where apps may listen on PORT. An app binding localhost can
+// get only ::1 where that resolves first, and then never answers on
+// 127.0.0.1.
+var loopbacks = []string{"127.0.0.1", "::1"}
+
+// dialLoopback returns the first loopback host that accepts on port.
+func dialLoopback(port int) (string, bool) {
+	for _, host := range loopbacks {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 200*time.Millisecond)
 		if err == nil {
 			conn.Close()
-			return nil
+			return host, true
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
-	return fmt.Errorf("TIMEOUT %s", addr)
+	return "", false
+}
+
+// portTaken reports whether something listens on port.
+func portTaken(port int) bool {
+	_, ok := dialLoopback(port)
+	return ok
+}
+
+// appPort is the port for a new instance: fixed-port: if the app ignores
+// PORT, which must not be taken already since mux could not tell the apps
+// apart, or else a free one.
+func appPort(pf *procfile) (int, error) {
+	if pf.fixedPort == 0 {
+		return freePort()
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", pf.fixedPort))
+	if err != nil {
+		return 0, fmt.Errorf("BUSY fixed-port: %d: %v", pf.fixedPort, err)
+	}
+	ln.Close()
+	return pf.fixedPort, nil
+}
+
+func parsePortRange(spec string) (int, int, error) {
+	if spec == "" {
+		return 0, 0, nil
+	}
+	lo, hi, ok := strings.Cut(spec, "-")
+	low, err1 := strconv.Atoi(lo)
+	high, err2 := strconv.Atoi(hi)
+	if !ok || err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("BAD -port-range %q, want LOW-HIGH", spec)
+	}
+	return low, high, nil
 }
 
-func loadInvertedIgnore(file string) (*ignore.GitIgnore, error) {
-	return ignore.CompileIgnoreFile(file)
+// waitPort returns the loopback host the app listens on, to proxy to.
+func waitPort(port int, timeout time.Duration, exited <-chan struct{}) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if host, ok := dialLoopback(port); ok {
+			return host, nil
+		}
+		select {
+		case <-exited:
+			return "", fmt.Errorf("EXITED before listening on port %d", port)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return "", fmt.Errorf("TIMEOUT port %d", port)
 }
 
-func matchInverted(path string, ig *ignore.GitIgnore) bool {
-	if ig == nil {
+func isExited(exited <-chan struct{}) bool {
+	select {
+	case <-exited:
+		return true
+	default:
 		return false
 	}
-	parts := strings.Split(path, string(filepath.Separator))
-	for _, part := range parts {
-		if strings.HasPrefix(part, ".") {
-			if !ig.MatchesPath(path) {
-				return false
+}
+
+// discoverPort runs command until it prints the port the app listens on,
+// as PORT or HOST:PORT like docker compose port does.
+func discoverPort(command, dir string, env []string, timeout time.Duration, exited <-chan struct{}) (int, error) {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		cmd := shellCommand(ctx, command)
+		cmd.Dir, cmd.Env = dir, env
+		out, err := cmd.Output()
+		cancel()
+		if err == nil {
+			fields := strings.Fields(string(out))
+			if len(fields) > 0 {
+				s := fields[0][strings.LastIndex(fields[0], ":")+1:]
+				if p, err := strconv.Atoi(s); err == nil && p > 0 && p < 65536 {
+					return p, nil
+				}
 			}
+			err = fmt.Errorf("no port in %q", strings.TrimSpace(string(out)))
+		}
+		lastErr = err
+		select {
+		case <-exited:
+			return 0, fmt.Errorf("EXITED before port-command: found a port")
+		case <-time.After(200 * time.Millisecond):
 		}
 	}
+	return 0, fmt.Errorf("TIMEOUT port-command: %s: %v", command, lastErr)
+}
+
+func waitReady(addr, path string, timeout time.Duration, exited <-chan struct{}) error {
+	deadline := time.Now().Add(timeout)
+	u := "http://" + addr + path
+	client := &http.Client{Timeout: time.Second}
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(u)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				return nil
+			}
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("EXITED before ready at %s", u)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("NOT READY %s", u)
+}
+
+// warmup requests path once so the app compiles or caches whatever its first
+// request needs before users reach it. Failures are only logged.
+func warmup(name, addr, path string, timeout time.Duration) {
+	u := "http://" + addr + path
+	resp, err := (&http.Client{Timeout: timeout}).Get(u)
+	if err != nil {
+		warnf("WARMUP: %s %s: %v", name, path, err)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		warnf("WARMUP: %s %s: %s", name, path, resp.Status)
+		return
+	}
+	debugf("WARMUP: %s %s %s", name, path, resp.Status)
+}
+
+func appSet(spec string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func checkDirTemplate(t string) error {
+	if !strings.Contains(t, "{app}") {
+		return fmt.Errorf("BAD -dir-template %q: missing {app}", t)
+	}
+	if filepath.IsAbs(t) {
+		return fmt.Errorf("BAD -dir-template %q: must be relative to -dir", t)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(t), "/") {
+		if part == ".." {
+			return fmt.Errorf("BAD -dir-template %q: must stay inside -dir", t)
+		}
+	}
+	return nil
+}
+
+func rootDir(root, name string) string {
+	return filepath.Join(root, strings.ReplaceAll(dirTemplate, "{app}", name))
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// systemDirs are never apps, though a root may contain them.
+var systemDirs = map[string]bool{
+	"lost+found":                true,
+	"node_modules":              true,
+	"$RECYCLE.BIN":              true,
+	"System Volume Information": true,
+}
+
+// validName reports whether name can be an app. Routing, -status, the
+// dashboard and -check all go through it, so hidden and system directories
+// are never apps anywhere.
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !systemDirs[name] && !strings.ContainsAny(name, `/\`)
+}
+
+// appDir resolves name against each -dir root in order; the first root that
+// has the app wins, and a missing app resolves into the first root.
+func appDir(name string) (string, error) {
+	if !validName(name) {
+		return "", fmt.Errorf("BAD app name %q", name)
+	}
+	for _, root := range roots {
+		if dir := rootDir(root, name); isDir(dir) {
+			return dir, nil
+		}
+	}
+	return rootDir(roots[0], name), nil
+}
 
-	return ig.MatchesPath(path)
+func discoverApps() ([]string, error) {
+	names, conflicts, err := scanApps()
+	for _, name := range names {
+		if msg, ok := conflicts[name]; ok {
+			errorf("CONFLICT: %s", msg)
+		}
+	}
+	return names, err
 }
 
-func addRecursive(w *fsnotify.Watcher, root string) error {
-	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+// scanApps lists the apps in all roots. A name claimed more than once, by
+// several roots or by an -alias, maps to a message naming the sources and the
+// one that is served: the first root, and apps before aliases.
+func scanApps() ([]string, map[string]string, error) {
+	var names []string
+	seen := map[string]string{}
+	conflicts := map[string]string{}
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		if d.IsDir() {
-			if strings.HasPrefix(d.Name(), ".") {
-				return filepath.SkipDir
+		for _, e := range entries {
+			name := e.Name()
+			if !validName(name) || !isDir(rootDir(root, name)) {
+				continue
+			}
+			if first, ok := seen[name]; ok {
+				conflicts[name] = fmt.Sprintf("%s is in %s and %s, serving %s", name, first, root, rootDir(first, name))
+				continue
 			}
-			return w.Add(path)
+			seen[name] = root
+			names = append(names, name)
 		}
-		return nil
-	})
+	}
+	for _, name := range names {
+		if app, ok := aliases[name]; ok {
+			msg := fmt.Sprintf("-alias %s=%s shadows app %s in %s, serving the app", name, app, name, seen[name])
+			if prev, ok := conflicts[name]; ok {
+				msg = prev + "; " + msg
+			}
+			conflicts[name] = msg
+		}
+	}
+	return names, conflicts, nil
 }
 
+// scanTTL bounds how often the admin list, health summary and dashboard
+// read every root, which adds up with hundreds of apps and a polling monitor.
+const scanTTL = 2 * time.Second
+
+var scanCache struct {
+	sync.Mutex
+	at        time.Time
+	names     []string
+	conflicts map[string]string
+}
+
+// cachedScan is scanApps, reusing a result younger than scanTTL. Callers
+// must not modify what it returns.
+func cachedScan() ([]string, map[string]string, error) {
+	scanCache.Lock()
+	defer scanCache.Unlock()
+	if time.Since(scanCache.at) < scanTTL {
+		return scanCache.names, scanCache.conflicts, nil
+	}
+	names, conflicts, err := scanApps()
+	if err != nil {
+		return nil, nil, err
+	}
+	scanCache.at, scanCache.names, scanCache.conflicts = time.Now(), names, conflicts
+	return names, conflicts, nil
+}
+
+func rootsLabel() string {
+	return strings.Join(roots, ",")
+}
+
+func checkRoot(create bool) error {
+	for _, root := range roots {
+		fi, err := os.Stat(root)
+		if os.IsNotExist(err) && create {
+			if err = os.MkdirAll(root, 0755); err != nil {
+				return err
+			}
+			infof("CREATED: %s", root)
+			fi, err = os.Stat(root)
+		}
+		if os.IsNotExist(err) {
+			return fmt.Errorf("NO -dir %s, create it or run with -init", root)
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("NOT a directory: -dir %s", root)
+		}
+	}
+	names, err := discoverApps()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		warnf("NO apps in %s, add one with %s/APP/Procfile", rootsLabel(), roots[0])
+	} else {
+		infof("APPS: %d in %s", len(names), rootsLabel())
+	}
+	return nil
+}
+
+// An app goes through these states:
+//
+//	release  if release: is set, it runs once and must exit zero
+//	booting  the web process is spawned and must listen on PORT within boot-timeout
+//	ready    if ready: is set, that path must answer below 500 within ready-timeout
+//	warmup   if warmup: is set, that path is requested once, failures are logged
+//	running  requests are proxied until the app idles, reloads, reaches
+//	         max-lifetime or is stopped
+//	failed   the process is killed and starts are refused until backoff has passed
 func start(name string) (*appInfo, error) {
-	dir := filepath.Join(root, name)
-	f, err := os.Open(filepath.Join(dir, "Procfile"))
+	dir, err := appDir(name)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := readProcfile(dir)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	command, overridden := overrides[name]
+	failed, hasFailed := failures[name]
+	mu.Unlock()
+	if overridden {
+		pf.web = command
+	}
+	if hasFailed && time.Since(failed) < pf.backoff {
+		return nil, fmt.Errorf("BACKOFF %s: failed %s ago", name, time.Since(failed).Round(time.Millisecond))
+	}
+	if err = checkPrecondition(name, dir, pf); err != nil {
+		return nil, err
+	}
+	var bl *bootLog
+	if asyncStart {
+		bl = beginBoot(name)
+	}
+	app, err := tryStart(name, dir, pf)
+	for retry := 1; err != nil && retry <= startRetries; retry++ {
+		delay := max(pf.backoff, minRetryDelay) << (retry - 1)
+		warnf("RETRY: %s %d/%d in %s: %v", name, retry, startRetries, delay, err)
+		time.Sleep(delay)
+		app, err = tryStart(name, dir, pf)
+	}
+	endBoot(name, bl, err)
+	mu.Lock()
+	defer mu.Unlock()
 	if err != nil {
+		failures[name] = time.Now()
 		return nil, err
 	}
-	defer f.Close()
+	delete(failures, name)
+	runHook(app, "start")
+	return app, nil
+}
 
-	var cmdStr string
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		if strings.HasPrefix(s.Text(), "web:") {
-			cmdStr = strings.TrimSpace(s.Text()[4:])
-			break
+func tryStart(name, dir string, pf *procfile) (*appInfo, error) {
+	if startSem != nil {
+		startSem <- struct{}{}
+		defer func() { <-startSem }()
+	}
+	dotEnv, env, err := startEnv(dir, pf)
+	if err != nil {
+		return nil, err
+	}
+	if err := release(name, dir, pf, env); err != nil {
+		return nil, err
+	}
+	// Another process can take the port between freePort and the app
+	// binding it; then the app fails and is started again on a new port.
+	for race := 1; ; race++ {
+		app, err := boot(name, dir, pf, dotEnv, env)
+		if !errors.Is(err, errPortTaken) || race == 3 {
+			return app, err
 		}
+		warnf("PORT RACE: %s: %v, trying another port", name, err)
+	}
+}
+
+func checkPrecondition(name, dir string, pf *procfile) error {
+	if pf.precondition == "" {
+		return nil
+	}
+	_, env, err := startEnv(dir, pf)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pf.bootTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, pf.precondition)
+	cmd.Dir, cmd.Env = dir, env
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
 	}
-	if cmdStr == "" {
-		return nil, fmt.Errorf("NO web: in %s/Procfile", dir)
+	msg := fmt.Sprintf("PRECONDITION %s: %s: %v", name, pf.precondition, err)
+	if out := strings.TrimSpace(string(out)); out != "" {
+		msg += "\n" + out
 	}
+	warnf("%s", msg)
+	return &statusError{http.StatusServiceUnavailable, errors.New(msg)}
+}
 
-	fp := freePort()
-	if verbose {
-		log.Printf("START: PWD=%s PORT=%d %s", dir, fp, cmdStr)
+// release runs the release: command before each start. start only runs
+// inside getApp's in-flight barrier, so concurrent first requests share one
+// release.
+func release(name, dir string, pf *procfile, env []string) error {
+	if pf.release == "" {
+		return nil
+	}
+	infof("RELEASE: %s %s", name, pf.release)
+	cmd := shellCommand(context.Background(), pf.release)
+	cmd.Dir, cmd.Env = dir, env
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if out := bootWriter(name); out != nil {
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(os.Stdout, out), io.MultiWriter(os.Stderr, out)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("RELEASE %s: %s: %v", name, pf.release, err)
+	}
+	return nil
+}
+
+// boot starts the web process with env from startEnv, plus PORT and the
+// other variables of this instance. dotEnv expands $VAR in the command.
+func boot(name, dir string, pf *procfile, dotEnv map[string]string, env []string) (*appInfo, error) {
+	cmdStr := pf.web
+	fp, err := appPort(pf)
+	if err != nil {
+		return nil, err
+	}
+	if pf.fixedPort == 0 {
+		defer unclaimPort(fp)
+	}
+	debugf("START: %s PWD=%s PORT=%d %s", name, dir, fp, cmdStr)
+	env = append(env[:len(env):len(env)], fmt.Sprintf("PORT=%d", fp), "MUX_ACTIVITY="+activityFile(dir))
+	_ = os.MkdirAll(filepath.Dir(activityFile(dir)), 0755)
+	var tmp string
+	if appTmp {
+		if tmp, err = makeTmp(dir); err != nil {
+			return nil, err
+		}
+		env = append(env, "TMPDIR="+tmp, "MUX_TMP="+tmp)
 	}
 	cmdParts := strings.Fields(cmdStr)
+	for i := range cmdParts {
+		cmdParts[i] = os.Expand(cmdParts[i], func(k string) string {
+			if k == "PORT" {
+				return fmt.Sprint(fp)
+			}
+			if v, ok := dotEnv[k]; ok {
+				return v
+			}
+			return getenv(k)
+		})
+	}
 	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
-	cmd.Dir, cmd.Env = dir, append(os.Environ(), fmt.Sprintf("PORT=%d", fp))
+	cmd.Dir, cmd.Env = dir, env
+	if runAs := pf.user; runAs != "" || runAsUser != "" {
+		if runAs == "" {
+			runAs = runAsUser
+		}
+		if err := setUser(cmd, runAs); err != nil {
+			_ = os.RemoveAll(tmp)
+			return nil, err
+		}
+	}
 	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	if err := cmd.Start(); err != nil {
+	var logs *logRing
+	var copies []io.Writer
+	if out := bootWriter(name); out != nil {
+		copies = append(copies, out)
+	}
+	if logLines > 0 {
+		logs = newLogRing(logLines)
+		copies = append(copies, logs)
+	}
+	if len(copies) > 0 {
+		cmd.Stdout = io.MultiWriter(append([]io.Writer{os.Stdout}, copies...)...)
+		cmd.Stderr = io.MultiWriter(append([]io.Writer{os.Stderr}, copies...)...)
+		cmd.WaitDelay = time.Second
+	}
+	setProcessGroup(cmd)
+	if foreground {
+		setForeground(cmd)
+	}
+	if ttyApps[name] && !foreground {
+		err = startTTY(cmd)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
 		return nil, err
 	}
-	if err := waitPort(fp, 5*time.Second); err != nil {
+	trackProcess(cmd.Process)
+	nice := defaultNice
+	if pf.nice != nil {
+		nice = *pf.nice
+	}
+	if nice != 0 {
+		if err := setNice(cmd.Process.Pid, nice); err != nil {
+			warnf("NICE: %s: %v", name, err)
+		}
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	if pf.portCommand != "" {
+		fp, err = discoverPort(pf.portCommand, dir, env, pf.bootTimeout, exited)
+	}
+	var host string
+	if err == nil {
+		host, err = waitPort(fp, pf.bootTimeout, exited)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(fp))
+	if err == nil && pf.ready != "" {
+		err = waitReady(addr, pf.ready, pf.readyTimeout, exited)
+	}
+	if err == nil && pf.warmup != "" {
+		warmup(name, addr, pf.warmup, pf.readyTimeout)
+	}
+	if pf.fixedPort == 0 && pf.portCommand == "" {
+		if err != nil && isExited(exited) && portTaken(fp) {
+			err = fmt.Errorf("%w: %v", errPortTaken, err)
+		} else if err == nil && !ownsPort(cmd.Process.Pid, fp) {
+			err = fmt.Errorf("%w: %d by PID %d", errPortTaken, fp, listenerPID(fp))
+		}
+	}
+	if err != nil {
+		_ = killProcess(cmd.Process)
+		go func() {
+			<-exited
+			_ = os.RemoveAll(tmp)
+		}()
 		return nil, err
 	}
 
-	u, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", fp))
+	if level >= levelDebug {
+		debugf("PID: %s %d, server %d: %s", name, cmd.Process.Pid, listenerPID(fp), cmdStr)
+	}
+	app := newAppInfo(name, dir, pf, host, fp, cmd)
+	app.logs, app.exited, app.tmp, app.env = logs, exited, tmp, env
+	go watchExit(app)
+	if pf.maxLifetime > 0 {
+		go recycle(app)
+	}
+	if err := saveRuntime(app, cmdStr); err != nil {
+		warnf("RUNTIME: %s: %v", name, err)
+	} else if err := savePID(app); err != nil {
+		warnf("PID: %s: %v", name, err)
+	}
+	return app, nil
+}
+
+func newAppInfo(name, dir string, pf *procfile, host string, port int, cmd *exec.Cmd) *appInfo {
+	u := &url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(port))}
 	proxy := httputil.NewSingleHostReverseProxy(u)
+	tr := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        maxIdleConnsPerHost,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableCompression:  true,
+		ReadBufferSize:      copyBufferSize,
+		WriteBufferSize:     copyBufferSize,
+	}
+	proxy.Transport = tr
+	proxy.BufferPool = copyBuffers
+	proxy.FlushInterval = flushInterval
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		warnf("PROXY: %s: %v", name, err)
+		w.Header().Set("Retry-After", retryAfter(pf))
+		appError(w, r, name, dir, proxyErrorStatus(err), err)
+	}
+	rewritePaths(proxy, pf.rewrites)
+	dumpBodies(name, proxy)
 
 	app := &appInfo{
 		name: name,
 		dir:  dir,
 		p:    proxy,
+		tr:   tr,
 		c:    cmd,
 		t:    time.Now(),
+		host: host,
+		port: port,
+		pf:   pf,
+
+		bufferRequest: pf.bufferRequest,
 	}
+	detectResets(app)
 
 	startWatcher(app)
 
-	return app, nil
+	return app
 }
 
 func stopApp(app *appInfo) {
-	if verbose {
-		log.Print("STOP: ", app.name)
+	mu.Lock()
+	stopped := stopLocked(app)
+	mu.Unlock()
+	if stopped {
+		runHook(app, "stop")
 	}
+}
+
+// stopLocked stops app unless it is already stopping; mu must be held, so a
+// request either finds the app before it stops or starts a new one after.
+func stopLocked(app *appInfo) bool {
+	if app.stopping {
+		return false
+	}
+	app.stopping = true
+	debugf("STOP: %s", app.name)
+	_ = killProcess(app.c.Process)
+	if app.exited == nil {
+		go func() { _, _ = app.c.Process.Wait() }()
+	}
+	app.tr.CloseIdleConnections()
+	stopWatcher(app)
+	removeRuntime(app)
+	removePID(app)
+	go removeTmp(app)
+	if apps[app.name] == app {
+		delete(apps, app.name)
+	}
+	return true
+}
+
+func watchExit(app *appInfo) {
+	<-app.exited
 	mu.Lock()
-	_ = app.c.Process.Kill()
-	app.watcher.Close()
-	delete(apps, app.name)
+	crashed := !app.stopping
+	app.crashed = crashed
 	mu.Unlock()
+	if crashed {
+		warnf("CRASH: %s exited", app.name)
+		runHook(app, "crash")
+		stopApp(app)
+	}
 }
 
-func startWatcher(app *appInfo) {
-	if app.watcher != nil {
-		app.watcher.Close()
+func (a *appInfo) done() {
+	mu.Lock()
+	a.t = time.Now()
+	var dirty string
+	if a.active.Add(-1) == 0 {
+		dirty, a.dirty = a.dirty, ""
 	}
+	mu.Unlock()
+	if dirty != "" {
+		reloadApp(a, dirty)
+	}
+}
 
-	ig, _ := loadInvertedIgnore(filepath.Join(app.dir, ".watch"))
-	app.ig = ig
+// isIdle reports whether a has had no requests for its idle TTL; open -tcp
+// streams, and with the connections strategy requests still in flight, keep
+// it running. mu must be held.
+func isIdle(a *appInfo) bool {
+	if a.streams.Load() > 0 || idleStrategy == "connections" && a.active.Load() > 0 {
+		return false
+	}
+	return time.Since(lastActive(a)) > appIdleTTL(a)
+}
 
-	watcher, _ := fsnotify.NewWatcher()
-	_ = addRecursive(watcher, app.dir)
-	app.watcher = watcher
+// appIdleTTL is the idle TTL set through the admin endpoint until the app
+// restarts, else its Procfile idle:, else -idle. mu must be held.
+func appIdleTTL(a *appInfo) time.Duration {
+	if a.idle > 0 {
+		return a.idle
+	}
+	if a.pf.idle > 0 {
+		return a.pf.idle
+	}
+	return idleTTL
+}
 
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Name == filepath.Join(app.dir, ".watch") {
-					if verbose {
-						log.Print("UPDATED: ", event.Name)
-					}
-					stopApp(app)
-				}
-				if event.Op&fsnotify.Create == fsnotify.Create {
-					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
-						if matchInverted(event.Name, ig) {
-							_ = addRecursive(watcher, event.Name)
-						}
-					}
-				}
-				if matchInverted(event.Name, ig) {
-					if verbose {
-						log.Print("UPDATED: ", event.Name)
-					}
-					stopApp(app)
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Print(err)
-			}
+type startCall struct {
+	done chan struct{}
+	app  *appInfo
+	err  error
+}
+
+func getApp(name, dir string) (*appInfo, error) {
+	mu.Lock()
+	if a, ok := apps[name]; ok {
+		a.t = time.Now()
+		mu.Unlock()
+		return a, nil
+	}
+	if c, ok := starting[name]; ok {
+		mu.Unlock()
+		<-c.done
+		if c.err != nil {
+			return nil, c.err
 		}
-	}()
+		mu.Lock()
+		c.app.t = time.Now()
+		mu.Unlock()
+		return c.app, nil
+	}
+	c := &startCall{done: make(chan struct{})}
+	starting[name] = c
+	mu.Unlock()
+
+	if c.app = adoptApp(name, dir); c.app == nil {
+		c.app, c.err = start(name)
+	}
+
+	mu.Lock()
+	delete(starting, name)
+	if c.err == nil {
+		c.app.t = time.Now()
+		apps[name] = c.app
+	}
+	mu.Unlock()
+	close(c.done)
+	return c.app, c.err
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	name := strings.TrimSuffix(strings.TrimSuffix(strings.Split(r.Host, ":")[0], domain), ".")
-	if name == "" {
+	if !clientAllowed(r) {
+		http.Error(w, "FORBIDDEN client "+r.RemoteAddr, http.StatusForbidden)
+		return
+	}
+	host := strings.Split(requestHost(r), ":")[0]
+	if !hostAllowed(host) {
+		http.Error(w, "UNKNOWN host "+host+", allow it with -allow-host", http.StatusMisdirectedRequest)
+		return
+	}
+	name := subdomainName(strings.TrimSuffix(strings.TrimSuffix(host, domain), "."))
+	apex := name == ""
+	if apex {
 		name = "www"
 	}
-	dir := filepath.Join(root, name)
-	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+	name = resolveName(name)
+	dir, err := appDir(name)
+	if (err != nil || !isDir(dir)) && pathFallback {
+		if pname, pdir, ok := pathApp(r); ok {
+			name, dir, err = pname, pdir, nil
+		}
+	}
+	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	if _, err := os.Stat(filepath.Join(dir, "Procfile")); os.IsNotExist(err) {
-		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	if !isDir(dir) {
+		if apex && dashboardOn {
+			serveDashboard(w, r)
+			return
+		}
+		http.NotFound(w, r)
 		return
 	}
-	mu.Lock()
-	a, ok := apps[name]
-	if !ok {
-		newApp, err := start(name)
-		if err != nil {
-			mu.Unlock()
-			http.Error(w, err.Error(), 500)
+	if !isDynamic(dir) {
+		serveStatic(w, r, dir)
+		return
+	}
+	if !allowed(accessRules(name, dir), r) {
+		http.Error(w, "FORBIDDEN by "+name+" Procfile", http.StatusForbidden)
+		return
+	}
+	if answerOptions && r.Method == http.MethodOptions {
+		serveOptions(w, dynamicMethods)
+		return
+	}
+	if asyncStart && r.URL.Path == bootLogPath {
+		serveBootLog(w, r, name)
+		return
+	}
+	if asyncStart && !startInBackground(name, dir) {
+		if wantsHTML(r) {
+			serveSplash(w, name)
 			return
 		}
-		apps[name] = newApp
-		a = newApp
+		w.Header().Set("Retry-After", "1")
+		appError(w, r, name, dir, http.StatusServiceUnavailable, fmt.Errorf("STARTING %s, retry shortly", name))
+		return
+	}
+	a, err := waitApp(name, dir)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "1")
+		} else if pf, perr := readProcfile(dir); perr == nil && status >= http.StatusInternalServerError {
+			w.Header().Set("Retry-After", retryAfter(pf))
+		}
+		appError(w, r, name, dir, status, err)
+		return
+	}
+	a.active.Add(1)
+	defer a.done()
+	capture(name, r)
+	setForwarded(r)
+	r, cancel := withTimeout(r)
+	defer cancel()
+	if a.bufferRequest {
+		if err := bufferBody(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	serveTimed(a, w, r)
+}
+
+// startInBackground reports whether name is running, and otherwise starts it
+// without waiting for it to become ready.
+func startInBackground(name, dir string) bool {
+	mu.Lock()
+	_, running := apps[name]
+	mu.Unlock()
+	if !running {
+		go func() {
+			if _, err := getApp(name, dir); err != nil {
+				warnf("START: %s: %v", name, err)
+			}
+		}()
+	}
+	return running
+}
+
+func bufferBody(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.TransferEncoding = nil
+	return nil
+}
+
+// startReaper starts reapIdle unless -no-idle keeps every app running, and
+// reports whether it did.
+func startReaper() bool {
+	if noIdle {
+		return false
+	}
+	go reapIdle()
+	return true
+}
+
+func reapIdle() {
+	for range time.Tick(min(idleTTL, 30*time.Second)) {
+		reapOnce()
+	}
+}
+
+// reapOnce stops the idle apps. A request either finds an app before it is
+// reaped, which refreshes its idle time, or starts a fresh one after.
+func reapOnce() {
+	var idle []*appInfo
+	mu.Lock()
+	for _, a := range apps {
+		if isIdle(a) {
+			debugf("IDLE: %s", a.name)
+			stopLocked(a)
+			idle = append(idle, a)
+		}
 	}
-	a.t = time.Now()
 	mu.Unlock()
-	a.p.ServeHTTP(w, r)
+	for _, a := range idle {
+		runHook(a, "stop")
+	}
 }
 
 type program struct{}
@@ -248,29 +1060,44 @@ func (p *program) Start(s service.Service) error {
 }
 
 func (p *program) run() {
-	go func() {
-		for range time.Tick(30 * time.Second) {
-			mu.Lock()
-			for _, a := range apps {
-				if time.Since(a.t) > idleTTL {
-					if verbose {
-						log.Print("IDLE: ", a.name)
-					}
-					stopApp(a)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
+	if adminAddr != "" || adminSocket != "" {
+		go serveAdmin()
+	}
+	startReaper()
+	for _, m := range tcpMappings {
+		go serveTCP(m)
+	}
+	handleRestart()
+	if acmeEmail != "" {
+		url := fmt.Sprintf("https://%s:%s", domain, tlsPort)
+		infof("%s (%s)", strings.TrimSuffix(url, ":443"), rootsLabel())
+		log.Fatal(serveACME(http.HandlerFunc(handler)))
+	}
+	if certFile != "" {
+		url := fmt.Sprintf("https://%s:%s", domain, tlsPort)
+		infof("%s (%s)", strings.TrimSuffix(url, ":443"), rootsLabel())
+		log.Fatal(serveCertFiles(http.HandlerFunc(handler)))
+	}
 	url := fmt.Sprintf("http://%s:%s", domain, port)
-	log.Printf("%s (%s)", strings.TrimSuffix(url, ":80"), root)
-	log.Fatal(http.ListenAndServe(":"+port, http.HandlerFunc(handler)))
+	infof("%s (%s)", strings.TrimSuffix(url, ":80"), rootsLabel())
+	serve(&http.Server{Handler: http.HandlerFunc(handler)}, ":"+port)
 }
 
 func (p *program) Stop(s service.Service) error {
 	return nil
 }
 
+func absPath(p string) (string, error) {
+	if strings.HasPrefix(p, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, p[1:])
+	}
+	return filepath.Abs(p)
+}
+
 func main() {
 	var err error
 	var s service.Service
@@ -284,10 +1111,23 @@ func main() {
 			"\n",
 			"Setup apps:\n",
 			"  ~/Web/APP/Procfile:  web: ./start.sh $PORT\n",
-			"  ~/Web/APP/.watch:    src/*\n",
+			"  ~/Web/APP/start.sh:  used as web command when there is no Procfile\n",
+			"  ~/Web/APP/package.json: its start script is used when there is neither\n",
+			"  ~/Web/APP/.watch:    src/**\n",
+			"                       !src/generated/**\n",
+			"  .watch uses .gitignore syntax for files that reload the app, last match wins.\n",
+			"  A .watch path like config/app.yml names one file, even in a hidden directory.\n",
+			"  $VAR in .watch expands from .env files or the environment, $$ is a literal $.\n",
+			"  Procfile watch-ext: go,html also reloads on those files, .watch can exclude them.\n",
+			"  Editing the Procfile, package.json or .watch always reloads the app.\n",
+			"  Procfile reload: deferred waits for requests in flight to finish first.\n",
+			"  Touching $MUX_ACTIVITY from any app process keeps the app from idling.\n",
+			"  ~/Web/.env, ~/Web/APP/.env and ~/Web/APP/.env.local set env, later files win.\n",
 			"\n",
 			"Visiting http://APP.localhost will start and serve the app.\n",
 			"\n",
+			"Upgrade mux without stopping apps with: kill -USR2 PID\n",
+			"\n",
 			"Options:\n",
 		)
 		flag.PrintDefaults()
@@ -295,20 +1135,209 @@ func main() {
 	}
 	enableFlag := flag.Bool("enable", false, "start on boot")
 	disableFlag := flag.Bool("disable", false, "disable start on boot")
-	dirFlag := flag.String("dir", "~/Web", "directory to serve applications from")
+	dirFlag := flag.String("dir", "~/Web", "directory to serve applications from, or a comma-separated list searched in order")
+	envFlag := flag.String("env", "", "prefer Procfile.ENV over Procfile and set MUX_ENV=ENV for apps")
+	startRetriesFlag := flag.Int("start-retries", 0, "retry a failed start this many times, waiting backoff: doubled each time, before failing the request")
+	maxStartsFlag := flag.Int("max-concurrent-starts", 4, "max apps starting at once, 0 for no limit")
+	runFlag := flag.Bool("run", false, "run APP with the COMMAND after -- until mux restarts, or back with its Procfile without one")
+	printConfigFlag := flag.Bool("print-config", false, "print the effective settings and whether each came from a flag or its default")
+	statusFlag := flag.Bool("status", false, "list apps with their type and state from the running mux")
+	gcFlag := flag.Bool("gc", false, "remove what stopped apps left in .mux, and temp dirs and *.log files older than -gc-retention")
+	gcRetentionFlag := flag.Duration("gc-retention", defaultRetention, "age after which -gc removes *.log files, and old temp dirs of running apps")
+	stopAllFlag := flag.Bool("stop-all", false, "stop every running app, they start again on the next request")
+	replayFlag := flag.Bool("replay", false, "send the last request -capture kept for APP again and print the response")
+	reloadFlag := flag.Bool("reload", false, "reload the running APP now, e.g. after an external build")
+	stopFlag := flag.Bool("stop", false, "stop the running APP, it starts again on the next request")
+	logsFlag := flag.Bool("logs", false, "print the output -log-lines kept for the running APP")
+	doctorFlag := flag.Bool("doctor", false, "check the environment mux runs in and exit")
+	staticCacheFlag := flag.String("static-cache", "", "Cache-Control max-age for static files, e.g. /assets/*=24h,*.css=1h, first match wins")
+	answerOptionsFlag := flag.Bool("answer-options", false, "answer OPTIONS for dynamic apps without starting them, not for apps doing CORS")
+	runOnlyFlag := flag.String("run-only", "", "run just the app `APP` attached to the terminal, reloading on changes, until it exits")
+	newFlag := flag.String("new", "", "create the app `APP` from a -type template and exit")
+	typeFlag := flag.String("type", "static", "template for -new: node, go or static")
+	checkFlag := flag.Bool("check", false, "check the Procfiles of the given apps, or all apps, and exit")
+	initFlag := flag.Bool("init", false, "create -dir if it doesn't exist")
+	dirTemplateFlag := flag.String("dir-template", dirTemplate, "path of an app inside -dir, e.g. {app}/current")
 	hostFlag := flag.String("host", "localhost", "serve on http://*.HOST")
 	portFlag := flag.String("port", "7777", "port to listen on")
-	verboseFlag := flag.Bool("verbose", false, "verbose logging")
+	portRangeFlag := flag.String("port-range", "", "pick app ports from LOW-HIGH instead of any free port")
+	verboseFlag := flag.Bool("verbose", false, "verbose logging, same as -log-level debug")
+	quietFlag := flag.Bool("quiet", false, "log nothing but fatal errors")
+	logLevelFlag := flag.String("log-level", "info", "log level: error, warn, info or debug")
+	logJSONFlag := flag.Bool("log-json", false, "log JSON lines with time, level, app, event and msg fields")
+	defaultNiceFlag := flag.Int("default-nice", 0, "run apps at this niceness, -20 to 19, unless the Procfile sets nice:")
+	runAsFlag := flag.String("run-as", "", "run apps as this user unless the Procfile sets user:")
+	acmeFlag := flag.String("acme", "", "get Let's Encrypt certificates for HOST and *.HOST with this email (needs -port 80)")
+	acmeCacheFlag := flag.String("acme-cache", "~/.cache/mux/acme", "directory to cache Let's Encrypt certificates in")
+	certFileFlag := flag.String("tls-cert", "", "serve HTTPS with this certificate file, reloaded when it changes")
+	keyFileFlag := flag.String("tls-key", "", "key file for -tls-cert")
+	tlsPortFlag := flag.String("tls-port", "443", "port to listen on for HTTPS with -acme or -tls-cert")
+	logLinesFlag := flag.Int("log-lines", 0, "keep this many lines of app output for the admin logs endpoint")
+	dumpFlag := flag.String("dump-bodies", "", "log request and response bodies of these apps, * for all (debugging only)")
+	bufferSizeFlag := flag.Int("buffer-size", copyBufferSize, "bytes buffered per proxied request and TCP stream, larger for throughput")
+	flushIntervalFlag := flag.Duration("flush-interval", 0, "flush proxied responses this often, -1ns after every write for lowest latency")
+	captureFlag := flag.String("capture", "", "keep the last request of these apps, APP,APP or *, for -replay")
+	dumpSizeFlag := flag.Int("dump-size", dumpSize, "max bytes of each body logged by -dump-bodies")
+	allowIPFlag := flag.String("allow-ip", "", "IPs or CIDRs of the only clients served, like 192.168.1.0/24 (default all)")
+	denyIPFlag := flag.String("deny-ip", "", "IPs or CIDRs of clients refused with 403, even if -allow-ip matches")
+	trustedProxyFlag := flag.String("trusted-proxy", "", "IPs or CIDRs of reverse proxies whose X-Forwarded-Host and -Proto are used")
+	responseTimeoutFlag := flag.Duration("response-timeout", 0, "give up on proxied requests after this with 504, 0 for no limit; X-Mux-Timeout from loopback or -trusted-proxy overrides it")
+	slowFlag := flag.Duration("slow", slowRequest, "warn about proxied requests slower than this, 0 to disable")
+	ttyFlag := flag.String("tty", "", "run these apps in a pseudo-terminal")
+	hookFlags := map[string]*string{}
+	for _, event := range hookEvents {
+		hookFlags[event] = flag.String("on-"+event, "", "shell command to run when an app "+hookVerbs[event]+", unless its Procfile sets on-"+event+":")
+	}
+	startWaitFlag := flag.Duration("start-wait", 0, "answer 503 to requests that wait longer than this for their app to start, 0 to wait for the boot")
+	startQueueFlag := flag.Int("start-queue", 0, "answer 503 to requests beyond this many waiting for one app to start, 0 for no limit")
+	asyncStartFlag := flag.Bool("async-start", false, "answer 503 with Retry-After while an app starts instead of holding the request")
+	idleFlag := flag.Duration("idle", idleTTL, "stop apps after this long without requests")
+	idleStrategyFlag := flag.String("idle-strategy", idleStrategy, "time to idle apps by last request, or connections to also wait for open requests to finish")
+	allowHostFlag := flag.String("allow-host", "", "also answer to these hosts besides localhost and -host, e.g. myapp.test,*.lan or * for any")
+	subdomainsFlag := flag.String("subdomains", subdomains, "app for a.b.HOST: exact for a.b, leftmost for a, rightmost for b")
+	defaultProcfileFlag := flag.String("default-procfile", "", "Procfile for apps without one, start.sh or package.json, instead of serving them as static files")
+	spaFlag := flag.Bool("spa", false, "serve index.html for unknown paths without an asset extension in static apps")
+	pathFallbackFlag := flag.Bool("path-fallback", false, "route /APP/... to APP when the host names no app")
+	evictOnResetFlag := flag.Bool("evict-on-reset", true, "stop an app whose connection resets mid-request, false to only log it")
+	noIdleFlag := flag.Bool("no-idle", false, "never stop idle apps, they run until stopped")
+	cleanEnvFlag := flag.Bool("clean-env", false, "pass apps only the -env-keep variables from mux's environment")
+	envKeepFlag := flag.String("env-keep", envKeep, "variables apps inherit with -clean-env")
+	tmpFlag := flag.Bool("tmp", false, "give each app its own TMPDIR in APP/.mux/tmp, removed when it stops")
+	hashCheckFlag := flag.Bool("hash-check", false, "only reload when a changed file's content differs from when mux last saw it")
+	dashboardFlag := flag.Bool("dashboard", false, "list apps at http://localhost when there is no www app")
+	aliasFlag := flag.String("alias", "", "serve apps at other subdomains too, e.g. api=my-long-service-name")
+	httpBehaviorFlag := flag.String("http-behavior", httpBehavior, "what -port does with HTTPS on: serve apps too, or redirect to HTTPS")
+	clientCertFlag := flag.Bool("client-cert", false, "ask HTTPS clients for a certificate and forward it as X-Client-Cert-* headers")
+	http3Flag := flag.Bool("http3", false, "EXPERIMENTAL, may change: also serve HTTPS over HTTP/3 (QUIC) on -tls-port")
+	tcpFlag := flag.String("tcp", "", "forward raw TCP from local ports to apps, e.g. 6379:redis,5432:db")
+	adminFlag := flag.String("admin", "", "also serve admin endpoints on this address, like localhost:7778, without -run overrides")
+	adminSocketFlag := flag.String("admin-socket", adminSocket, "unix socket to serve admin endpoints on, also used by -status, -stop, -logs and other commands, empty to disable")
+	maxBodyFlag := flag.Int64("max-body", maxBody, "max request body size in bytes for apps with buffer-request:")
 	flag.Parse()
 
-	root, domain, port, verbose = *dirFlag, *hostFlag, *portFlag, *verboseFlag
-	if strings.HasPrefix(root, "~") {
-		root = filepath.Join(os.Getenv("HOME"), root[1:])
+	domain, port = *hostFlag, *portFlag
+	if *logJSONFlag {
+		setLogJSON()
+	}
+	level, err = parseLogLevel(*logLevelFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *verboseFlag {
+		level = levelDebug
+	}
+	if *quietFlag {
+		level = levelQuiet
+	}
+	runAsUser, maxBody, dirTemplate = *runAsFlag, *maxBodyFlag, *dirTemplateFlag
+	if defaultNice = *defaultNiceFlag; defaultNice < -20 || defaultNice > 19 {
+		log.Fatalf("BAD -default-nice %d, want -20 to 19", defaultNice)
+	}
+	adminAddr, adminSocket, muxEnv, logLines = *adminFlag, *adminSocketFlag, *envFlag, *logLinesFlag
+	if err = checkDirTemplate(dirTemplate); err != nil {
+		log.Fatal(err)
+	}
+	dumpApps, dumpSize = appSet(*dumpFlag), *dumpSizeFlag
+	captureApps = appSet(*captureFlag)
+	if copyBufferSize, flushInterval = *bufferSizeFlag, *flushIntervalFlag; copyBufferSize < 1024 {
+		log.Fatal("BAD -buffer-size: want at least 1024")
+	}
+	if len(dumpApps) > 0 {
+		warnf("DUMP: logging request and response bodies of %s, this is for debugging only", *dumpFlag)
+	}
+	idleTTL, idleStrategy, asyncStart = *idleFlag, *idleStrategyFlag, *asyncStartFlag
+	if idleStrategy != "time" && idleStrategy != "connections" {
+		log.Fatalf("BAD -idle-strategy %q: want time or connections", idleStrategy)
+	}
+	for event, command := range hookFlags {
+		globalHooks[event] = *command
+	}
+	slowRequest, ttyApps = *slowFlag, appSet(*ttyFlag)
+	responseTimeout = *responseTimeoutFlag
+	startRetries = *startRetriesFlag
+	if *maxStartsFlag > 0 {
+		startSem = make(chan struct{}, *maxStartsFlag)
+	}
+	if portLow, portHigh, err = parsePortRange(*portRangeFlag); err != nil {
+		log.Fatal(err)
+	}
+	if trustedProxies, err = parsePrefixes("trusted-proxy", *trustedProxyFlag); err != nil {
+		log.Fatal(err)
+	}
+	if allowedIPs, err = parsePrefixes("allow-ip", *allowIPFlag); err != nil {
+		log.Fatal(err)
+	}
+	if deniedIPs, err = parsePrefixes("deny-ip", *denyIPFlag); err != nil {
+		log.Fatal(err)
+	}
+	if staticCache, err = parseStaticCache(*staticCacheFlag); err != nil {
+		log.Fatal(err)
+	}
+	if tcpMappings, err = parseTCPMappings(*tcpFlag); err != nil {
+		log.Fatal(err)
+	}
+	allowedHosts, dashboardOn, hashCheck = parseHosts(*allowHostFlag), *dashboardFlag, *hashCheckFlag
+	appTmp, pathFallback, evictOnReset = *tmpFlag, *pathFallbackFlag, *evictOnResetFlag
+	noIdle, spa, answerOptions = *noIdleFlag, *spaFlag, *answerOptionsFlag
+	if defaultProcfile = *defaultProcfileFlag; defaultProcfile != "" {
+		if defaultProcfile, err = absPath(defaultProcfile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if subdomains = *subdomainsFlag; subdomains != "exact" && subdomains != "leftmost" && subdomains != "rightmost" {
+		log.Fatalf("BAD -subdomains %q: want exact, leftmost or rightmost", subdomains)
+	}
+	cleanEnv, envKeep = *cleanEnvFlag, *envKeepFlag
+	startWait, startQueue = *startWaitFlag, *startQueueFlag
+	if aliases, err = parseAliases(*aliasFlag); err != nil {
+		log.Fatal(err)
+	}
+	acmeEmail, acmeCache, tlsPort, http3On = *acmeFlag, *acmeCacheFlag, *tlsPortFlag, *http3Flag
+	certFile, keyFile = *certFileFlag, *keyFileFlag
+	if (certFile == "") != (keyFile == "") {
+		log.Fatal("BAD -tls-cert: needs -tls-key, and the other way round")
+	}
+	if certFile != "" && acmeEmail != "" {
+		log.Fatal("BAD -tls-cert: use either it or -acme")
+	}
+	for _, file := range []*string{&certFile, &keyFile} {
+		if *file != "" {
+			if *file, err = absPath(*file); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	tlsOn := acmeEmail != "" || certFile != ""
+	if http3On && !tlsOn {
+		log.Fatal("BAD -http3: needs -acme or -tls-cert for TLS")
+	}
+	if clientCert = *clientCertFlag; clientCert && !tlsOn {
+		log.Fatal("BAD -client-cert: needs -acme or -tls-cert for TLS")
 	}
-	root, err = filepath.Abs(root)
+	if httpBehavior = *httpBehaviorFlag; httpBehavior != "serve" && httpBehavior != "redirect" {
+		log.Fatalf("BAD -http-behavior %q: want serve or redirect", httpBehavior)
+	}
+	for _, root := range strings.Split(*dirFlag, ",") {
+		if root = strings.TrimSpace(root); root == "" {
+			continue
+		}
+		if root, err = absPath(root); err != nil {
+			log.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		log.Fatal("BAD -dir: no directories")
+	}
+	acmeCache, err = absPath(acmeCache)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if adminSocket != "" {
+		if adminSocket, err = absPath(adminSocket); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	currentUser, err := user.Current()
 	if err != nil {
@@ -319,9 +1348,68 @@ func main() {
 		Name:        "mux",
 		DisplayName: "Mux Web Server",
 		Arguments: []string{
-			fmt.Sprintf("-dir=%s", root),
+			fmt.Sprintf("-dir=%s", rootsLabel()),
+			fmt.Sprintf("-dir-template=%s", dirTemplate),
+			fmt.Sprintf("-env=%s", muxEnv),
 			fmt.Sprintf("-host=%s", domain),
 			fmt.Sprintf("-port=%s", port),
+			fmt.Sprintf("-port-range=%s", *portRangeFlag),
+			fmt.Sprintf("-max-concurrent-starts=%d", *maxStartsFlag),
+			fmt.Sprintf("-start-retries=%d", startRetries),
+			fmt.Sprintf("-run-as=%s", runAsUser),
+			fmt.Sprintf("-default-nice=%d", defaultNice),
+			fmt.Sprintf("-acme=%s", acmeEmail),
+			fmt.Sprintf("-acme-cache=%s", acmeCache),
+			fmt.Sprintf("-tls-port=%s", tlsPort),
+			fmt.Sprintf("-tls-cert=%s", certFile),
+			fmt.Sprintf("-tls-key=%s", keyFile),
+			fmt.Sprintf("-max-body=%d", maxBody),
+			fmt.Sprintf("-admin=%s", adminAddr),
+			fmt.Sprintf("-admin-socket=%s", adminSocket),
+			fmt.Sprintf("-log-lines=%d", logLines),
+			fmt.Sprintf("-tcp=%s", *tcpFlag),
+			fmt.Sprintf("-http3=%v", http3On),
+			fmt.Sprintf("-client-cert=%v", clientCert),
+			fmt.Sprintf("-http-behavior=%s", httpBehavior),
+			fmt.Sprintf("-alias=%s", *aliasFlag),
+			fmt.Sprintf("-allow-host=%s", *allowHostFlag),
+			fmt.Sprintf("-dashboard=%t", dashboardOn),
+			fmt.Sprintf("-hash-check=%t", hashCheck),
+			fmt.Sprintf("-tmp=%t", appTmp),
+			fmt.Sprintf("-clean-env=%t", cleanEnv),
+			fmt.Sprintf("-env-keep=%s", envKeep),
+			fmt.Sprintf("-path-fallback=%t", pathFallback),
+			fmt.Sprintf("-spa=%t", spa),
+			fmt.Sprintf("-static-cache=%s", *staticCacheFlag),
+			fmt.Sprintf("-answer-options=%t", answerOptions),
+			fmt.Sprintf("-default-procfile=%s", defaultProcfile),
+			fmt.Sprintf("-subdomains=%s", subdomains),
+			fmt.Sprintf("-evict-on-reset=%t", evictOnReset),
+			fmt.Sprintf("-no-idle=%t", noIdle),
+			fmt.Sprintf("-start-wait=%s", startWait),
+			fmt.Sprintf("-start-queue=%d", startQueue),
+			fmt.Sprintf("-tty=%s", *ttyFlag),
+			fmt.Sprintf("-idle=%s", idleTTL),
+			fmt.Sprintf("-async-start=%v", asyncStart),
+			fmt.Sprintf("-idle-strategy=%s", idleStrategy),
+			fmt.Sprintf("-on-start=%s", globalHooks["start"]),
+			fmt.Sprintf("-on-stop=%s", globalHooks["stop"]),
+			fmt.Sprintf("-on-reload=%s", globalHooks["reload"]),
+			fmt.Sprintf("-on-crash=%s", globalHooks["crash"]),
+			fmt.Sprintf("-trusted-proxy=%s", *trustedProxyFlag),
+			fmt.Sprintf("-allow-ip=%s", *allowIPFlag),
+			fmt.Sprintf("-deny-ip=%s", *denyIPFlag),
+			fmt.Sprintf("-slow=%s", slowRequest),
+			fmt.Sprintf("-response-timeout=%s", responseTimeout),
+			fmt.Sprintf("-dump-bodies=%s", *dumpFlag),
+			fmt.Sprintf("-dump-size=%d", dumpSize),
+			fmt.Sprintf("-capture=%s", *captureFlag),
+			fmt.Sprintf("-buffer-size=%d", copyBufferSize),
+			fmt.Sprintf("-flush-interval=%s", flushInterval),
+			fmt.Sprintf("-log-level=%s", *logLevelFlag),
+			fmt.Sprintf("-verbose=%t", *verboseFlag),
+			fmt.Sprintf("-quiet=%t", *quietFlag),
+			fmt.Sprintf("-log-json=%t", logJSON),
 		},
 		EnvVars: map[string]string{
 			"PATH": os.Getenv("PATH"),
@@ -338,6 +1426,102 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *printConfigFlag {
+		printConfig(os.Stdout, svcConfig.Arguments)
+		return
+	}
+
+	if *runFlag {
+		if err = runOverride(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *statusFlag {
+		if err = adminRequest(http.MethodGet, "/apps", nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *stopAllFlag {
+		if err = adminRequest(http.MethodPost, "/apps/stop", nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *gcFlag {
+		if err = adminRequest(http.MethodPost, "/gc?older-than="+gcRetentionFlag.String(), nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *replayFlag {
+		if err = runReplay(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *reloadFlag {
+		if err = runReload(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *stopFlag {
+		if err = runStop(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *logsFlag {
+		if err = runLogs(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *doctorFlag {
+		if !runDoctor() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *runOnlyFlag != "" {
+		code, err := runOnly(*runOnlyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	}
+
+	if *newFlag != "" {
+		if err = newApp(*newFlag, *typeFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *checkFlag {
+		if !checkApps(flag.Args()) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !*disableFlag {
+		if err = checkRoot(*initFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if *enableFlag {
 		if err = s.Install(); err != nil {
 			log.Print(err)
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBin is this test binary, which apps in tests run as their backend.
+var testBin string
+
+// TestMain runs the binary as a backend when an app started it with
+// MUX_TEST_BACKEND set, so tests start real apps without other programs.
+func TestMain(m *testing.M) {
+	if os.Getenv("MUX_TEST_BACKEND") != "" {
+		testBackend()
+		return
+	}
+	var err error
+	if testBin, err = os.Executable(); err != nil {
+		log.Fatal(err)
+	}
+	level = levelQuiet
+	os.Exit(m.Run())
+}
+
+// testBackend is a web app listening on PORT, after MUX_TEST_DELAY if set,
+// with MUX_TEST_BACKEND=exit one that fails at once, or with echo a TCP
+// server that echoes lines.
+func testBackend() {
+	switch os.Getenv("MUX_TEST_BACKEND") {
+	case "exit":
+		os.Exit(1)
+	case "echo":
+		echoBackend()
+		return
+	}
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "PORT=%s args=%s", os.Getenv("PORT"), strings.Join(os.Args[1:], " "))
+	})
+	http.HandleFunc("/env/{key}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getenv(r.PathValue("key")))
+	})
+	http.HandleFunc("/header/{name}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Join(r.Header.Values(r.PathValue("name")), ","))
+	})
+	http.HandleFunc("/pid", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, os.Getpid())
+	})
+	http.HandleFunc("/path/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+	http.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		_, _ = io.Copy(w, r.Body)
+	})
+	http.HandleFunc("/body", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, "length=%d chunked=%t read=%d", r.ContentLength, slices.Contains(r.TransferEncoding, "chunked"), n)
+	})
+	http.HandleFunc("/print/{line}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.PathValue("line"))
+	})
+	http.HandleFunc("/sleep/{d}", func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.PathValue("d"))
+		time.Sleep(d)
+	})
+	http.HandleFunc("/crash", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		os.Exit(1)
+	})
+	http.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
+	http.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	})
+	http.HandleFunc("/status/{code}", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.PathValue("code"))
+		w.WriteHeader(code)
+	})
+	if delay, err := time.ParseDuration(os.Getenv("MUX_TEST_DELAY")); err == nil {
+		time.Sleep(delay)
+	}
+	// MUX_TEST_HOST binds one stack only, like [::1].
+	log.Fatal(http.ListenAndServe(os.Getenv("MUX_TEST_HOST")+":"+os.Getenv("PORT"), nil))
+}
+
+func echoBackend() {
+	ln, err := net.Listen("tcp", ":"+os.Getenv("PORT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			defer c.Close()
+			_, _ = io.Copy(c, c)
+		}()
+	}
+}
+
+// testRoot makes a temporary -dir with apps on *.localhost, and stops the
+// apps a test started when it ends.
+func testRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	savedRoots, savedDomain, savedHosts := roots, domain, allowedHosts
+	roots, domain = []string{root}, "localhost"
+	allowedHosts = parseHosts("")
+	scanCache.Lock()
+	scanCache.at = time.Time{}
+	scanCache.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		var running []*appInfo
+		for _, app := range apps {
+			running = append(running, app)
+		}
+		mu.Unlock()
+		for _, app := range running {
+			stopApp(app)
+			if app.exited != nil {
+				<-app.exited
+			}
+		}
+		mu.Lock()
+		clear(failures)
+		clear(overrides)
+		mu.Unlock()
+		roots, domain, allowedHosts = savedRoots, savedDomain, savedHosts
+	})
+	return root
+}
+
+// writeFiles creates files, relative to dir, with their contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testApp creates app name in root running testBackend, with more Procfile
+// lines, and returns its directory.
+func testApp(t *testing.T, root, name, procfile string) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: " + testBin + "\n" + procfile,
+		".env":     "MUX_TEST_BACKEND=1\n",
+	})
+	return dir
+}
+
+// get sends a request for path on host through handler.
+func get(host, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://"+host+path, nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// testProcess starts a process for an app in tests that serves elsewhere,
+// and returns it with a channel closed when it exits.
+func testProcess(t *testing.T) (*exec.Cmd, chan struct{}) {
+	t.Helper()
+	cmd := exec.Command(testBin)
+	cmd.Env = append(os.Environ(), "MUX_TEST_BACKEND=1", "PORT=0")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-exited
+	})
+	return cmd, exited
+}
+
+func TestStopClosesIdleConns(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+
+	cmd, exited := testProcess(t)
+	app := newAppInfo("idle", t.TempDir(), &procfile{}, addr.IP.String(), addr.Port, cmd)
+	app.exited = exited
+	app.p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	select {
+	case <-closed:
+		t.Fatal("connection closed before the app stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+	stopApp(app)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("idle connection to the stopped app still open")
+	}
+}
+
+// upload posts body to path on host through handler as a chunked stream.
+func upload(host, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "http://"+host+path, io.NopCloser(strings.NewReader(body)))
+	r.ContentLength, r.TransferEncoding = -1, []string{"chunked"}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestBufferRequest(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "stream", "")
+	testApp(t, root, "buffer", "buffer-request: true\n")
+	saved := maxBody
+	maxBody = 10
+	defer func() { maxBody = saved }()
+
+	if got := upload("stream.localhost", "/body", "hello").Body.String(); got != "length=-1 chunked=true read=5" {
+		t.Errorf("streamed upload: %s", got)
+	}
+	if got := upload("buffer.localhost", "/body", "hello").Body.String(); got != "length=5 chunked=false read=5" {
+		t.Errorf("buffered upload: %s", got)
+	}
+	if w := upload("buffer.localhost", "/body", "hello world"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("buffered upload over -max-body: status %d: %s", w.Code, w.Body)
+	}
+	if got := upload("stream.localhost", "/body", "hello world").Body.String(); got != "length=-1 chunked=true read=11" {
+		t.Errorf("streamed upload over -max-body: %s", got)
+	}
+}
+
+func TestCheckDirTemplate(t *testing.T) {
+	for tmpl, ok := range map[string]bool{
+		"{app}":              true,
+		"apps/{app}/current": true,
+		"current":            false,
+		"/srv/{app}":         false,
+		"../{app}":           false,
+		"{app}/../other":     false,
+	} {
+		if err := checkDirTemplate(tmpl); (err == nil) != ok {
+			t.Errorf("checkDirTemplate(%q) = %v, want ok %v", tmpl, err, ok)
+		}
+	}
+}
+
+func TestDirTemplate(t *testing.T) {
+	root := testRoot(t)
+	dirTemplate = "apps/{app}/current"
+	defer func() { dirTemplate = "{app}" }()
+	testApp(t, root, "apps/blog/current", "")
+
+	dir, err := appDir("blog")
+	if want := filepath.Join(root, "apps", "blog", "current"); err != nil || dir != want {
+		t.Errorf("appDir(blog) = %q, %v, want %q", dir, err, want)
+	}
+	for _, name := range []string{"../blog", "a/b", ".hidden"} {
+		if dir, err := appDir(name); err == nil {
+			t.Errorf("appDir(%q) = %q, want an error", name, dir)
+		}
+	}
+	if w := get("blog.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestCheckRoot(t *testing.T) {
+	root := testRoot(t)
+	missing := filepath.Join(root, "missing")
+	roots = []string{missing}
+	if err := checkRoot(false); err == nil || !strings.HasPrefix(err.Error(), "NO -dir "+missing) {
+		t.Errorf("missing root: %v, want NO -dir %s", err, missing)
+	}
+
+	buf := captureLog(t, levelInfo)
+	if err := checkRoot(true); err != nil {
+		t.Fatal(err)
+	}
+	if !isDir(missing) {
+		t.Fatal("-init didn't create the root")
+	}
+	if !strings.Contains(buf.String(), "NO apps in "+missing) {
+		t.Errorf("empty root logged %q, want NO apps", buf)
+	}
+
+	writeFiles(t, missing, map[string]string{"blog/index.html": "", "file": ""})
+	buf.Reset()
+	if err := checkRoot(false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "APPS: 1 in "+missing) {
+		t.Errorf("root with an app logged %q, want APPS: 1", buf)
+	}
+	roots = []string{filepath.Join(missing, "file")}
+	if err := checkRoot(false); err == nil || !strings.HasPrefix(err.Error(), "NOT a directory") {
+		t.Errorf("file as root: %v, want NOT a directory", err)
+	}
+}
+
+func TestStartTimeouts(t *testing.T) {
+	root := testRoot(t)
+	for _, tt := range []struct {
+		name, procfile, delay string
+		err                   string
+	}{
+		{"slow-boot", "boot-timeout: 300ms\n", "1s", "TIMEOUT port"},
+		{"boot", "boot-timeout: 2s\n", "300ms", ""},
+		{"unready", "ready: /status/503\nready-timeout: 300ms\n", "", "NOT READY"},
+		{"ready", "ready: /status/404\nready-timeout: 300ms\n", "", ""},
+	} {
+		dir := testApp(t, root, tt.name, tt.procfile)
+		writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\nMUX_TEST_DELAY=" + tt.delay + "\n"})
+		began := time.Now()
+		app, err := getApp(tt.name, dir)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+			t.Errorf("%s: %v, want %s", tt.name, err, tt.err)
+		}
+		if took := time.Since(began); took > 2*time.Second {
+			t.Errorf("%s: start took %s", tt.name, took)
+		}
+		if err == nil && app.port == 0 {
+			t.Errorf("%s: started without a port", tt.name)
+		}
+	}
+}
+
+func TestStartBackoff(t *testing.T) {
+	root := testRoot(t)
+	dir := filepath.Join(root, "crashing")
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: " + testBin + "\nbackoff: 500ms\n",
+		".env":     "MUX_TEST_BACKEND=exit\n",
+	})
+	if _, err := start("crashing"); err == nil || !strings.HasPrefix(err.Error(), "EXITED") {
+		t.Fatalf("first start: %v, want EXITED", err)
+	}
+	if _, err := start("crashing"); err == nil || !strings.HasPrefix(err.Error(), "BACKOFF crashing") {
+		t.Errorf("start within backoff: %v, want BACKOFF", err)
+	}
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\n"})
+	time.Sleep(500 * time.Millisecond)
+	app, err := start("crashing")
+	if err != nil {
+		t.Fatalf("start after backoff: %v", err)
+	}
+	stopApp(app)
+	<-app.exited
+	mu.Lock()
+	_, failing := failures["crashing"]
+	mu.Unlock()
+	if failing {
+		t.Error("failure kept after a successful start")
+	}
+}
+
+func TestStartRetries(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	// flaky fails its first two boots and counts them in boots.
+	dir := filepath.Join(root, "flaky")
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: sh boot.sh\n",
+		"boot.sh":  "echo >> boots\n[ $(wc -l < boots) -ge 3 ] || exit 1\nMUX_TEST_BACKEND=1 exec " + testBin + "\n",
+	})
+	saved := startRetries
+	defer func() { startRetries = saved }()
+	boots := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "boots"))
+		return strings.Count(string(data), "\n")
+	}
+
+	startRetries = 1
+	if w := get("flaky.localhost", "/"); w.Code == http.StatusOK || boots() != 2 {
+		t.Fatalf("one retry: status %d after %d boots, want a failure after 2", w.Code, boots())
+	}
+	os.Remove(filepath.Join(dir, "boots"))
+	mu.Lock()
+	delete(failures, "flaky")
+	mu.Unlock()
+	startRetries = 2
+	if w := get("flaky.localhost", "/"); w.Code != http.StatusOK || boots() != 3 {
+		t.Errorf("two retries: status %d after %d boots, want success on the third", w.Code, boots())
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if low, high, err := parsePortRange("9000-9010"); err != nil || low != 9000 || high != 9010 {
+		t.Errorf("parsePortRange = %d, %d, %v", low, high, err)
+	}
+	for _, spec := range []string{"9000", "9010-9000", "0-10", "9000-70000", "a-b"} {
+		if _, _, err := parsePortRange(spec); err == nil {
+			t.Errorf("parsePortRange(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestFreePortRange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	taken := ln.Addr().(*net.TCPAddr).Port
+	defer func() { portLow, portHigh = 0, 0 }()
+
+	portLow, portHigh = taken, taken
+	if p, err := freePort(); err == nil {
+		unclaimPort(p)
+		t.Errorf("freePort = %d from a range with only a busy port", p)
+	}
+	portLow, portHigh = taken-50, taken+50
+	got := map[int]bool{}
+	for range 5 {
+		p, err := freePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unclaimPort(p)
+		if p < portLow || p > portHigh || p == taken || got[p] {
+			t.Errorf("freePort = %d, want another free port in %d-%d", p, portLow, portHigh)
+		}
+		got[p] = true
+	}
+}
+
+// needShell skips tests whose Procfile commands are sh scripts.
+func needShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands need sh")
+	}
+}
+
+func TestMaxConcurrentStarts(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	startSem = make(chan struct{}, 2)
+	defer func() { startSem = nil }()
+	// Each release counts the releases running with it.
+	running, counts := t.TempDir(), filepath.Join(t.TempDir(), "counts")
+	release := fmt.Sprintf("release: touch %[1]s/$$ && ls %[1]s | wc -l >> %[2]s && sleep 0.2 && rm %[1]s/$$\n", running, counts)
+	var started sync.WaitGroup
+	for i := range 5 {
+		name := fmt.Sprintf("herd%d", i)
+		dir := testApp(t, root, name, release)
+		started.Add(1)
+		go func() {
+			defer started.Done()
+			if _, err := getApp(name, dir); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	started.Wait()
+	data, err := os.ReadFile(counts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := strings.Fields(string(data))
+	if len(seen) != 5 {
+		t.Fatalf("%d releases ran, want 5", len(seen))
+	}
+	for _, n := range seen {
+		if n != "1" && n != "2" {
+			t.Errorf("%s starts ran at once, want at most 2", n)
+		}
+	}
+	if !slices.Contains(seen, "2") {
+		t.Error("starts never overlapped")
+	}
+}
+
+func TestMultipleRoots(t *testing.T) {
+	first := testRoot(t)
+	second := t.TempDir()
+	roots = append(roots, second)
+	testApp(t, second, "api", "")
+	writeFiles(t, first, map[string]string{"docs/index.html": "first"})
+	writeFiles(t, second, map[string]string{"docs/index.html": "second", "site/index.html": "site"})
+
+	if w := get("api.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("app in the second root: %d %s", w.Code, w.Body)
+	}
+	if w := get("site.localhost", "/"); w.Body.String() != "site" {
+		t.Errorf("static app in the second root: %d %s", w.Code, w.Body)
+	}
+	if w := get("docs.localhost", "/"); w.Body.String() != "first" {
+		t.Errorf("app in both roots served %q, want the first root's", w.Body)
+	}
+	if dir, _ := appDir("new"); dir != filepath.Join(first, "new") {
+		t.Errorf("missing app resolves to %s, want the first root", dir)
+	}
+
+	names, conflicts, err := scanApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"docs", "api", "site"}; !slices.Equal(names, want) {
+		t.Errorf("apps %v, want %v", names, want)
+	}
+	want := "docs is in " + first + " and " + second + ", serving " + filepath.Join(first, "docs")
+	if conflicts["docs"] != want || len(conflicts) != 1 {
+		t.Errorf("conflicts %q, want docs: %s", conflicts, want)
+	}
+}
+
+func TestPrecondition(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	dir := testApp(t, root, "gated", `precondition: test -f "$FLAG" || { echo db not reachable; exit 1; }`+"\n")
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\nFLAG=ready.flag\n"})
+
+	w := get("gated.localhost", "/")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("unmet precondition: status %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "PRECONDITION gated: ") || !strings.Contains(body, "\ndb not reachable") {
+		t.Errorf("unmet precondition said %q", body)
+	}
+	mu.Lock()
+	started := apps["gated"] != nil
+	mu.Unlock()
+	if started {
+		t.Error("app started despite its precondition")
+	}
+
+	writeFiles(t, dir, map[string]string{"ready.flag": ""})
+	if w := get("gated.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("met precondition: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestIdleStrategy(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "busy", "idle: 50ms\n")
+	get("busy.localhost", "/")
+	mu.Lock()
+	app := apps["busy"]
+	mu.Unlock()
+	idle := func(strategy string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		idleStrategy = strategy
+		return isIdle(app)
+	}
+	defer func() { idleStrategy = "time" }()
+
+	served := make(chan struct{})
+	go func() {
+		get("busy.localhost", "/sleep/500ms")
+		close(served)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if idle("connections") {
+		t.Error("connections: idle with a request in flight")
+	}
+	if !idle("time") {
+		t.Error("time: not idle 200ms after the last request began")
+	}
+	<-served
+	if idle("connections") {
+		t.Error("connections: idle right after the request ended")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !idle("connections") {
+		t.Error("connections: not idle after the TTL without requests")
+	}
+}
+
+func TestReleaseOnce(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	dir := testApp(t, root, "released", "release: echo ran >> release.log; sleep 0.2\n")
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = get("released.localhost", "/").Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status %d", i, code)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "release.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "ran\n"); n != 1 {
+		t.Errorf("release ran %d times for concurrent first requests, want once", n)
+	}
+}
+
+func TestAsyncStart(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "warming", "")
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\nMUX_TEST_DELAY=300ms\n"})
+	asyncStart = true
+	defer func() { asyncStart = false }()
+
+	began := time.Now()
+	w := get("warming.localhost", "/")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("first request: status %d Retry-After %q, want 503 and 1", w.Code, w.Header().Get("Retry-After"))
+	}
+	if took := time.Since(began); took > 200*time.Millisecond {
+		t.Errorf("first request held for %s while the app started", took)
+	}
+	waitFor(t, "the app to start in the background", func() bool {
+		return get("warming.localhost", "/").Code == http.StatusOK
+	})
+}
+
+func TestAbsPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	got, err := absPath("~/Web")
+	if err != nil || got != filepath.Join(home, "Web") {
+		t.Errorf("absPath(~/Web) = %q, %v, want %s", got, err, filepath.Join(home, "Web"))
+	}
+	if got, _ := absPath("Web"); !filepath.IsAbs(got) {
+		t.Errorf("absPath(Web) = %q, want an absolute path", got)
+	}
+}
+
+func TestRequestDuringReap(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "reaped", "idle: 20ms\n")
+	// Each request arrives as the reaper finds the app idle, before or after
+	// it stops it.
+	for i := range 20 {
+		get("reaped.localhost", "/")
+		time.Sleep(30 * time.Millisecond)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reapOnce()
+		}()
+		w := get("reaped.localhost", "/")
+		wg.Wait()
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d racing the reaper: status %d: %s", i, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPortCommand(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	// The app stands in for a container that publishes its port on mapped.
+	dir := filepath.Join(root, "compose")
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: env PORT=$MAPPED " + testBin + "\nport-command: echo 0.0.0.0:$MAPPED\n",
+		".env":     "MUX_TEST_BACKEND=1\nMAPPED=" + mapped + "\n",
+	})
+	if w := get("compose.localhost", "/"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PORT="+mapped+" ") {
+		t.Errorf("status %d: %s, want the app on the mapped port %s", w.Code, w.Body, mapped)
+	}
+	mu.Lock()
+	app := apps["compose"]
+	mu.Unlock()
+	if app == nil || strconv.Itoa(app.port) != mapped {
+		t.Errorf("proxying to %v, want port %s", app, mapped)
+	}
+
+	testApp(t, root, "unmapped", "port-command: echo\nboot-timeout: 300ms\n")
+	if w := get("unmapped.localhost", "/"); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "TIMEOUT port-command: echo: no port in") {
+		t.Errorf("port-command without a port: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestFixedPort(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	// The app binds fixed whatever PORT says.
+	writeFiles(t, filepath.Join(root, "legacy"), map[string]string{
+		"Procfile": "web: env PORT=" + fixed + " " + testBin + "\nfixed-port: " + fixed + "\n",
+		".env":     "MUX_TEST_BACKEND=1\n",
+	})
+	if w := get("legacy.localhost", "/"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PORT="+fixed+" ") {
+		t.Fatalf("status %d: %s, want the app on port %s", w.Code, w.Body, fixed)
+	}
+
+	mu.Lock()
+	app := apps["legacy"]
+	mu.Unlock()
+	stopApp(app)
+	<-app.exited
+	held, err := net.Listen("tcp", "127.0.0.1:"+fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	if w := get("legacy.localhost", "/"); w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "BUSY fixed-port: "+fixed) {
+		t.Errorf("fixed port taken: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestNoIdle(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "forever", "")
+	savedTTL := idleTTL
+	idleTTL, noIdle = 20*time.Millisecond, true
+	defer func() { idleTTL, noIdle = savedTTL, false }()
+	if startReaper() {
+		t.Fatal("reaper started with -no-idle")
+	}
+	get("forever.localhost", "/")
+	mu.Lock()
+	app := apps["forever"]
+	mu.Unlock()
+	time.Sleep(10 * idleTTL)
+	mu.Lock()
+	running := apps["forever"] == app
+	mu.Unlock()
+	if !running || isExited(app.exited) {
+		t.Error("app stopped past the idle TTL with -no-idle")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	root := testRoot(t)
+	logLines = 10
+	defer func() { logLines = 0 }()
+	testApp(t, root, "warm", "warmup: /print/warmup\n")
+	if w := get("warm.localhost", "/print/user"); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	app := apps["warm"]
+	mu.Unlock()
+	waitFor(t, "the output", func() bool { return len(app.logs.tail(-1)) == 2 })
+	if got := app.logs.tail(-1); !slices.Equal(got, []string{"warmup", "user"}) {
+		t.Errorf("app saw %q, want the warmup path before the first request", got)
+	}
+}
+
+func TestDefaultProcfile(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, filepath.Join(root, "bare"), map[string]string{".env": "MUX_TEST_BACKEND=1\n", "index.html": "static"})
+	testApp(t, root, "own", "")
+	if w := get("bare.localhost", "/"); w.Body.String() != "static" {
+		t.Fatalf("without -default-procfile: %q, want the static file", w.Body)
+	}
+	defaultProcfile = filepath.Join(t.TempDir(), "Procfile")
+	defer func() { defaultProcfile = "" }()
+	if err := os.WriteFile(defaultProcfile, []byte("web: "+testBin+" default\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("bare.localhost", "/"); w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), "args=default") {
+		t.Errorf("app without a Procfile: %d %s, want the default command", w.Code, w.Body)
+	}
+	if w := get("own.localhost", "/"); !strings.HasSuffix(w.Body.String(), "args=") {
+		t.Errorf("app with a Procfile: %s, want its own command", w.Body)
+	}
+}
+
+func TestLoopbackStacks(t *testing.T) {
+	if ln, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	} else {
+		ln.Close()
+	}
+	for _, stacks := range [][]string{{"127.0.0.1"}, {"::1"}, {"127.0.0.1", "::1"}} {
+		port, err := freePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range stacks {
+			ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+		}
+		if host, err := waitPort(port, time.Second, nil); err != nil || host != stacks[0] {
+			t.Errorf("listening on %v: waitPort = %q, %v, want %s", stacks, host, err, stacks[0])
+		}
+	}
+
+	root := testRoot(t)
+	dir := testApp(t, root, "six", "")
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=1\nMUX_TEST_HOST=[::1]\n"})
+	if w := get("six.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("app on ::1 only: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type appMetrics struct {
+	watchEvents   atomic.Int64
+	watchFiltered atomic.Int64
+	watchReloads  atomic.Int64
+	watchErrors   atomic.Int64
+
+	latency histogram
+}
+
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+type histogram struct {
+	counts [12]atomic.Int64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) total() int64 {
+	var n int64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// quantile returns the upper bound of the bucket holding the q-th request.
+func (h *histogram) quantile(q float64) time.Duration {
+	n := h.total()
+	if n == 0 {
+		return 0
+	}
+	rank := int64(float64(n)*q + 0.5)
+	var seen int64
+	for i, b := range latencyBuckets {
+		if seen += h.counts[i].Load(); seen >= rank {
+			return b
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   = map[string]*appMetrics{}
+)
+
+func metricsFor(name string) *appMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m, ok := metrics[name]
+	if !ok {
+		m = &appMetrics{}
+		metrics[name] = m
+	}
+	return m
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	metricsMu.Unlock()
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	counters := []struct {
+		name  string
+		value func(*appMetrics) int64
+	}{
+		{"mux_watch_events_total", func(m *appMetrics) int64 { return m.watchEvents.Load() }},
+		{"mux_watch_filtered_total", func(m *appMetrics) int64 { return m.watchFiltered.Load() }},
+		{"mux_watch_reloads_total", func(m *appMetrics) int64 { return m.watchReloads.Load() }},
+		{"mux_watch_errors_total", func(m *appMetrics) int64 { return m.watchErrors.Load() }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{app=%q} %d\n", c.name, name, c.value(metricsFor(name)))
+		}
+	}
+
+	fmt.Fprintf(w, "# TYPE mux_request_duration_seconds histogram\n")
+	for _, name := range names {
+		h := &metricsFor(name).latency
+		var cumulative int64
+		for i, b := range latencyBuckets {
+			cumulative += h.counts[i].Load()
+			fmt.Fprintf(w, "mux_request_duration_seconds_bucket{app=%q,le=\"%g\"} %d\n", name, b.Seconds(), cumulative)
+		}
+		fmt.Fprintf(w, "mux_request_duration_seconds_bucket{app=%q,le=\"+Inf\"} %d\n", name, h.total())
+		fmt.Fprintf(w, "mux_request_duration_seconds_sum{app=%q} %g\n", name, time.Duration(h.sum.Load()).Seconds())
+		fmt.Fprintf(w, "mux_request_duration_seconds_count{app=%q} %d\n", name, h.total())
+	}
+	for _, q := range []struct {
+		name string
+		q    float64
+	}{{"p50", 0.5}, {"p95", 0.95}} {
+		fmt.Fprintf(w, "# TYPE mux_request_duration_%s_seconds gauge\n", q.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "mux_request_duration_%s_seconds{app=%q} %g\n", q.name, name, metricsFor(name).latency.quantile(q.q).Seconds())
+		}
+	}
+}
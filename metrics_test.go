@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWatchMetrics(t *testing.T) {
+	metricsMu.Lock()
+	delete(metrics, "metered")
+	metricsMu.Unlock()
+	root := testRoot(t)
+	dir := testApp(t, root, "metered", "")
+	writeFiles(t, dir, map[string]string{".watch": "*.txt\n"})
+	first, err := getApp("metered", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metricsFor("metered")
+
+	writeFiles(t, dir, map[string]string{"debug.log": "x"})
+	waitFor(t, "the filtered event", func() bool { return m.watchFiltered.Load() == 1 })
+	if n := m.watchReloads.Load(); n != 0 {
+		t.Errorf("%d reloads for a filtered file", n)
+	}
+
+	writeFiles(t, dir, map[string]string{"a.txt": "x", "b.txt": "x"})
+	waitFor(t, "the reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["metered"] != first
+	})
+	if n := m.watchReloads.Load(); n != 1 {
+		t.Errorf("%d reloads, want 1", n)
+	}
+	if n := m.watchEvents.Load(); n < 2 {
+		t.Errorf("%d events, want at least 2", n)
+	}
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`mux_watch_filtered_total{app="metered"} 1`,
+		`mux_watch_reloads_total{app="metered"} 1`,
+		`mux_watch_errors_total{app="metered"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("metrics missing %s", line)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// answerOptions makes mux answer OPTIONS for dynamic apps itself, so probes
+// don't start or reach them. CORS preflights then get no Access-Control
+// headers, so apps that handle those need it off.
+var answerOptions = false
+
+const (
+	staticMethods  = "GET, HEAD, OPTIONS"
+	dynamicMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+)
+
+func serveOptions(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// send is get with another method.
+func send(method, host, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "http://"+host+path, nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestStaticHead(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{"site/page.txt": "hello"})
+	w := send("HEAD", "site.localhost", "/page.txt")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "5" {
+		t.Errorf("HEAD: %d with %d body bytes and Content-Length %q, want 200, none and 5", w.Code, w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+	w = send("OPTIONS", "site.localhost", "/page.txt")
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != staticMethods {
+		t.Errorf("OPTIONS: %d Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestAnswerOptions(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "cors", "")
+	testApp(t, root, "probed", "")
+	if w := send("OPTIONS", "cors.localhost", "/"); w.Code != http.StatusOK || w.Header().Get("Allow") != "" {
+		t.Errorf("without -answer-options: %d %s, want the app's answer", w.Code, w.Body)
+	}
+	answerOptions = true
+	defer func() { answerOptions = false }()
+	if w := send("OPTIONS", "probed.localhost", "/"); w.Code != http.StatusNoContent || w.Header().Get("Allow") != dynamicMethods {
+		t.Errorf("with -answer-options: %d Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	mu.Lock()
+	_, started := apps["probed"]
+	mu.Unlock()
+	if started {
+		t.Error("OPTIONS started the app")
+	}
+}
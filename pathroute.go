@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// pathFallback routes /APP/... to APP when the host names no app, for
+// clients that can't use subdomains.
+var pathFallback = false
+
+// pathApp resolves the first path segment of r to an app and strips it from
+// r, which the app sees under X-Forwarded-Prefix.
+func pathApp(r *http.Request) (name, dir string, ok bool) {
+	seg, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	name = resolveName(seg)
+	dir, err := appDir(name)
+	if err != nil || !isDir(dir) {
+		return "", "", false
+	}
+	prefix := "/" + seg
+	r.URL.Path = "/" + rest
+	r.URL.RawPath = ""
+	r.Header.Set("X-Forwarded-Prefix", prefix)
+	return name, dir, true
+}
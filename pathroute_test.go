@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPathFallback(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	testApp(t, root, "other", "")
+	testApp(t, root, "path", "")
+	if w := get("localhost", "/api/path/users"); w.Code != http.StatusNotFound {
+		t.Errorf("without -path-fallback: status %d", w.Code)
+	}
+	pathFallback = true
+	defer func() { pathFallback = false }()
+
+	if w := get("api.localhost", "/path/users"); w.Body.String() != "/path/users" {
+		t.Errorf("by host: backend saw %q", w.Body)
+	}
+	if w := get("localhost", "/api/path/users"); w.Body.String() != "/path/users" {
+		t.Errorf("by path: backend saw %q, want the prefix stripped", w.Body)
+	}
+	if w := get("localhost", "/api/header/X-Forwarded-Prefix"); w.Body.String() != "/api" {
+		t.Errorf("X-Forwarded-Prefix = %q, want /api", w.Body)
+	}
+	// The host wins over the path when it names an app.
+	if w := get("other.localhost", "/path/x"); w.Body.String() != "/path/x" {
+		t.Errorf("host app got %q, want the path untouched", w.Body)
+	}
+	mu.Lock()
+	running := len(apps)
+	mu.Unlock()
+	if running != 2 {
+		t.Errorf("%d apps running, want api and other", running)
+	}
+	if w := get("localhost", "/missing/x"); w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "PORT=") {
+		t.Errorf("unknown path app: status %d: %s", w.Code, w.Body)
+	}
+}
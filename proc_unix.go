@@ -0,0 +1,169 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+func setUser(cmd *exec.Cmd, name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	if euid := os.Geteuid(); euid != 0 && uint64(euid) != uid {
+		return fmt.Errorf("CANNOT run as %s: mux is not running as root", name)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username)
+	return nil
+}
+
+func findProcess(pid int) (*os.Process, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// handleRestart re-executes mux on SIGUSR2, handing the listeners of all
+// served servers over so no connection is refused meanwhile.
+func handleRestart() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	go func() {
+		<-sig
+		exe, err := os.Executable()
+		if err != nil {
+			errorf("RESTART: %v", err)
+			return
+		}
+		servedMu.Lock()
+		var fds []string
+		for addr, s := range served {
+			tl, ok := s.ln.(*net.TCPListener)
+			if !ok {
+				servedMu.Unlock()
+				errorf("RESTART: %s: cannot hand over a %T", addr, s.ln)
+				return
+			}
+			f, err := tl.File()
+			if err == nil {
+				_, err = unix.FcntlInt(f.Fd(), unix.F_SETFD, 0)
+			}
+			if err != nil {
+				servedMu.Unlock()
+				errorf("RESTART: %v", err)
+				return
+			}
+			fds = append(fds, fmt.Sprintf("%s=%d", addr, f.Fd()))
+		}
+		infof("RESTART: %s", exe)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, s := range served {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = s.srv.Shutdown(ctx)
+			}()
+		}
+		wg.Wait()
+		env := append(os.Environ(), "MUX_LISTEN_FDS="+strings.Join(fds, ","))
+		log.Fatal(syscall.Exec(exe, os.Args, env))
+	}()
+}
+
+// setProcessGroup puts the app in its own process group so killProcess also
+// stops anything it spawned.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// setNice sets the priority of the app's process group, which also covers
+// anything it spawned before this ran.
+func setNice(pid, nice int) error {
+	if err := unix.Setpriority(unix.PRIO_PGRP, pid, nice); err != nil {
+		return unix.Setpriority(unix.PRIO_PROCESS, pid, nice)
+	}
+	return nil
+}
+
+// setForeground gives the app mux's stdin and, on a terminal, makes it the
+// foreground job as a shell would, so it gets Ctrl-C and can be debugged.
+func setForeground(cmd *exec.Cmd) {
+	cmd.Stdin = os.Stdin
+	if _, err := unix.IoctlGetWinsize(int(os.Stdin.Fd()), unix.TIOCGWINSZ); err == nil {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+}
+
+var startScripts = []string{"start", "start.sh"}
+
+func isExecutable(fi os.FileInfo) bool {
+	return fi.Mode()&0111 != 0
+}
+
+func trackProcess(p *os.Process) {}
+
+func killProcess(p *os.Process) error {
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+	return p.Kill()
+}
+
+func startTTY(cmd *exec.Cmd) error {
+	out := cmd.Stdout
+	cmd.SysProcAttr.Setpgid = false
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+	f, err := pty.Start(cmd)
+	if err != nil {
+		return err
+	}
+	go func() {
+		_, _ = io.Copy(out, f)
+		f.Close()
+	}()
+	return nil
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
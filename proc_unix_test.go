@@ -0,0 +1,361 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func init() {
+	// /tty tells whether a testBackend has a terminal on stdin.
+	http.HandleFunc("/tty", func(w http.ResponseWriter, r *http.Request) {
+		_, err := unix.IoctlGetWinsize(0, unix.TIOCGWINSZ)
+		fmt.Fprint(w, err == nil)
+	})
+}
+
+func TestStartScript(t *testing.T) {
+	root := testRoot(t)
+	dir := filepath.Join(root, "script")
+	writeFiles(t, dir, map[string]string{
+		"start.sh": "#!/bin/sh\nMUX_TEST_BACKEND=1 exec " + testBin + " \"$@\"\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "start.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := get("script.localhost", "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	port := apps["script"].port
+	mu.Unlock()
+	if want := fmt.Sprintf("PORT=%d args=%d", port, port); w.Body.String() != want {
+		t.Errorf("body = %q, want %q", w.Body, want)
+	}
+}
+
+func TestStartScriptPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"start.sh": "#!/bin/sh\n", "start": "#!/bin/sh\n"})
+	if isDynamic(dir) {
+		t.Errorf("app with non-executable start scripts is dynamic")
+	}
+	for _, name := range []string{"start.sh", "start"} {
+		if err := os.Chmod(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := defaultCommand(dir); got != "./start $PORT" {
+		t.Errorf("defaultCommand = %q, want ./start $PORT", got)
+	}
+	writeFiles(t, dir, map[string]string{"Procfile": "web: ./server\n"})
+	pf, err := readProcfile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.web != "./server" {
+		t.Errorf("web = %q, want the Procfile's ./server", pf.web)
+	}
+}
+
+func TestPackageStart(t *testing.T) {
+	root := testRoot(t)
+	bin := t.TempDir()
+	for _, pm := range []string{"npm", "pnpm"} {
+		writeFiles(t, bin, map[string]string{pm: "#!/bin/sh\nMUX_TEST_BACKEND=1 exec " + testBin + " " + pm + " \"$@\"\n"})
+		if err := os.Chmod(filepath.Join(bin, pm), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	writeFiles(t, root, map[string]string{
+		"node/package.json":   `{"scripts": {"start": "node index.js"}}`,
+		"pnpm/package.json":   `{"scripts": {"start": "node index.js"}}`,
+		"pnpm/pnpm-lock.yaml": "",
+		"lib/package.json":    `{"name": "lib"}`,
+	})
+	for name, want := range map[string]string{"node": "args=npm start", "pnpm": "args=pnpm start"} {
+		if w := get(name+".localhost", "/"); w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), want) {
+			t.Errorf("%s: status %d: %s, want %s", name, w.Code, w.Body, want)
+		}
+	}
+	if isDynamic(filepath.Join(root, "lib")) {
+		t.Error("package.json without a start script is dynamic")
+	}
+}
+
+func TestSetUser(t *testing.T) {
+	if _, err := exec.LookPath("id"); err != nil {
+		t.Skip("no id command")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+	cmd := exec.Command("id", "-u")
+	err = setUser(cmd, "nobody")
+	if os.Geteuid() != 0 {
+		if err == nil || !strings.HasPrefix(err.Error(), "CANNOT run as nobody") {
+			t.Errorf("setUser without root = %v, want CANNOT run as nobody", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := strings.TrimSpace(string(out)); uid != nobody.Uid {
+		t.Errorf("child uid = %s, want %s", uid, nobody.Uid)
+	}
+}
+
+func TestSetUserUnknown(t *testing.T) {
+	if err := setUser(exec.Command("true"), "no-such-user-mux"); err == nil {
+		t.Error("setUser for a missing user succeeded")
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	old, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	f, err := old.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// listen closes the descriptor it inherits, so hand it a copy.
+	fd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := old.Addr().String()
+	t.Setenv("MUX_LISTEN_FDS", fmt.Sprintf("%s=%d", addr, fd))
+	inheritOnce = sync.Once{}
+
+	ln, err := listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != addr {
+		t.Fatalf("listening on %s, want the inherited %s", ln.Addr(), addr)
+	}
+	// The old mux stops accepting; the new one takes the connection.
+	old.Close()
+	go func() {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if os.Getenv("MUX_LISTEN_FDS") != "" {
+		t.Error("MUX_LISTEN_FDS left for the apps to inherit")
+	}
+	if _, ok := inherited[addr]; ok {
+		t.Error("inherited listener can be taken twice")
+	}
+}
+
+func TestTTY(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "term", "")
+	testApp(t, root, "pipe", "")
+	ttyApps["term"] = true
+	defer delete(ttyApps, "term")
+	logLines = 10
+	defer func() { logLines = 0 }()
+
+	if got := get("pipe.localhost", "/tty").Body.String(); got != "false" {
+		t.Errorf("app without -tty sees a terminal: %s", got)
+	}
+	if got := get("term.localhost", "/tty").Body.String(); got != "true" {
+		t.Fatalf("app with -tty sees no terminal: %s", got)
+	}
+	get("term.localhost", "/print/colored")
+	mu.Lock()
+	app := apps["term"]
+	mu.Unlock()
+	waitFor(t, "the output", func() bool {
+		lines := app.logs.tail(-1)
+		return len(lines) > 0 && strings.TrimSuffix(lines[len(lines)-1], "\r") == "colored"
+	})
+}
+
+// running reports whether pid is a live process, not a zombie left for
+// whatever reaps orphans here.
+func running(pid int) bool {
+	if _, err := findProcess(pid); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
+
+func TestPIDFile(t *testing.T) {
+	root := testRoot(t)
+	dir := filepath.Join(root, "wrapped")
+	// sh stays the parent of the server instead of exec'ing it.
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: sh serve.sh\n",
+		"serve.sh": "MUX_TEST_BACKEND=1 " + testBin + "\n",
+	})
+	server := get("wrapped.localhost", "/pid").Body.String()
+	data, err := os.ReadFile(pidFile(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || !running(pid) {
+		t.Fatalf("PID file %q names no running process", data)
+	}
+	mu.Lock()
+	app := apps["wrapped"]
+	mu.Unlock()
+	if runtime.GOOS == "linux" && strconv.Itoa(pid) != server {
+		t.Errorf("PID file has %d, want the server's %s rather than sh's %d", pid, server, app.c.Process.Pid)
+	}
+
+	stopApp(app)
+	<-app.exited
+	if _, err := os.Stat(pidFile(dir)); !os.IsNotExist(err) {
+		t.Errorf("PID file left after stop: %v", err)
+	}
+	waitFor(t, "the server to exit", func() bool { return !running(pid) })
+}
+
+func TestStopKillsGroup(t *testing.T) {
+	root := testRoot(t)
+	dir := filepath.Join(root, "forking")
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: ./run.sh\n",
+		"run.sh":   "#!/bin/sh\nsleep 300 &\necho $! > sleep.pid\nMUX_TEST_BACKEND=1 exec " + testBin + "\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	app, err := getApp("forking", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sleep.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grandchild, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	if !running(grandchild) {
+		t.Fatalf("grandchild %d not running", grandchild)
+	}
+
+	stopApp(app)
+	<-app.exited
+	if app.c.ProcessState == nil {
+		t.Error("app process not waited for")
+	}
+	waitFor(t, "the grandchild to die", func() bool { return !running(grandchild) })
+}
+
+func TestServerPID(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, filepath.Join(root, "wrapped"), map[string]string{
+		"Procfile": "web: sh serve.sh\n",
+		"serve.sh": "MUX_TEST_BACKEND=1 " + testBin + "\n",
+	})
+	server := get("wrapped.localhost", "/pid").Body.String()
+	mu.Lock()
+	shell := apps["wrapped"].c.Process.Pid
+	mu.Unlock()
+	st := appList(t, "")["wrapped"]
+	if st.PID != shell {
+		t.Errorf("status PID %d, want the command's %d", st.PID, shell)
+	}
+	if runtime.GOOS == "linux" && strconv.Itoa(st.ServerPID) != server {
+		t.Errorf("status server PID %d, want the listening process %s", st.ServerPID, server)
+	}
+}
+
+func TestPortRace(t *testing.T) {
+	root := testRoot(t)
+	dir := filepath.Join(root, "raced")
+	// The first run waits for the test to take its port, then fails to
+	// bind it.
+	writeFiles(t, dir, map[string]string{
+		"Procfile": "web: sh race.sh\n",
+		"race.sh": "if [ ! -e port ]; then\n echo $PORT > port\n while [ ! -e squatted ]; do sleep 0.05; done\n exit 1\nfi\n" +
+			"MUX_TEST_BACKEND=1 exec " + testBin + "\n",
+	})
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- get("raced.localhost", "/") }()
+
+	var port string
+	waitFor(t, "the first run", func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, "port"))
+		port = strings.TrimSpace(string(data))
+		return port != ""
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	writeFiles(t, dir, map[string]string{"squatted": ""})
+
+	w := <-done
+	if w.Code != http.StatusOK || w.Body.String() == "PORT="+port+" args=" {
+		t.Errorf("status %d: %s, want the app started again on another port than %s", w.Code, w.Body, port)
+	}
+}
+
+// niceness is the nice value of pid; Linux's getpriority returns 20-nice.
+func niceness(t *testing.T, pid int) int {
+	t.Helper()
+	prio, err := unix.Getpriority(unix.PRIO_PROCESS, pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "linux" {
+		return 20 - prio
+	}
+	return prio
+}
+
+func TestNice(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "background", "")
+	testApp(t, root, "batch", "nice: 10\n")
+	defaultNice = 5
+	defer func() { defaultNice = 0 }()
+	if own := niceness(t, os.Getpid()); own != 0 {
+		t.Skipf("mux runs at nice %d", own)
+	}
+	for name, want := range map[string]int{"background": 5, "batch": 10} {
+		pid, _ := strconv.Atoi(get(name+".localhost", "/pid").Body.String())
+		if got := niceness(t, pid); got != want {
+			t.Errorf("%s runs at nice %d, want %d", name, got, want)
+		}
+	}
+}
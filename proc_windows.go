@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func setUser(cmd *exec.Cmd, name string) error {
+	return fmt.Errorf("CANNOT run as %s: not supported on windows", name)
+}
+
+func setNice(pid, nice int) error {
+	return fmt.Errorf("CANNOT set nice %d: not supported on windows", nice)
+}
+
+func setForeground(cmd *exec.Cmd) {
+	cmd.Stdin = os.Stdin
+}
+
+func findProcess(pid int) (*os.Process, error) {
+	return os.FindProcess(pid)
+}
+
+func handleRestart() {}
+
+var startScripts = []string{"start.cmd", "start.bat"}
+
+func isExecutable(fi os.FileInfo) bool {
+	return true
+}
+
+// Apps run in a job object so killProcess also stops anything they spawned.
+var (
+	jobsMu sync.Mutex
+	jobs   = map[int]windows.Handle{}
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+func trackProcess(p *os.Process) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		warnf("JOB: %d: %v", p.Pid, err)
+		return
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE},
+	}
+	_, err = windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err == nil {
+		var h windows.Handle
+		if h, err = windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.Pid)); err == nil {
+			err = windows.AssignProcessToJobObject(job, h)
+			_ = windows.CloseHandle(h)
+		}
+	}
+	if err != nil {
+		_ = windows.CloseHandle(job)
+		warnf("JOB: %d: %v", p.Pid, err)
+		return
+	}
+	jobsMu.Lock()
+	jobs[p.Pid] = job
+	jobsMu.Unlock()
+}
+
+func killProcess(p *os.Process) error {
+	jobsMu.Lock()
+	job, ok := jobs[p.Pid]
+	delete(jobs, p.Pid)
+	jobsMu.Unlock()
+	if !ok {
+		return p.Kill()
+	}
+	defer windows.CloseHandle(job)
+	return windows.TerminateJobObject(job, 1)
+}
+
+func startTTY(cmd *exec.Cmd) error {
+	return fmt.Errorf("CANNOT run %s in a tty on windows", cmd.Path)
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellCommand(t *testing.T) {
+	out, err := shellCommand(context.Background(), "echo one && echo two").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("output %q, want one and two", out)
+	}
+}
+
+func TestKillProcessJob(t *testing.T) {
+	// cmd starts ping as a child, which only the job object reaches.
+	cmd := shellCommand(context.Background(), "ping -n 30 127.0.0.1 > NUL")
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	trackProcess(cmd.Process)
+	jobsMu.Lock()
+	_, tracked := jobs[cmd.Process.Pid]
+	jobsMu.Unlock()
+	if !tracked {
+		t.Fatal("process not assigned to a job object")
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	if err := killProcess(cmd.Process); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process still running after killProcess")
+	}
+	jobsMu.Lock()
+	_, tracked = jobs[cmd.Process.Pid]
+	jobsMu.Unlock()
+	if tracked {
+		t.Error("job object kept after killProcess")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type procfile struct {
+	web    string
+	user   string
+	ready  string
+	warmup string
+
+	precondition string
+	release      string
+	portCommand  string
+	envCommand   string
+	fixedPort    int
+	nice         *int
+	watchExt     []string
+	rewrites     []rewriteRule
+	access       []accessRule
+
+	bootTimeout  time.Duration
+	readyTimeout time.Duration
+	backoff      time.Duration
+	idle         time.Duration
+	maxLifetime  time.Duration
+	retryAfter   time.Duration
+
+	bufferRequest bool
+	deferReload   bool
+
+	hooks map[string]string
+}
+
+const (
+	defaultBootTimeout  = 5 * time.Second
+	defaultReadyTimeout = 5 * time.Second
+)
+
+type procfileError struct {
+	file string
+	line int
+	msg  string
+	warn bool
+}
+
+func (e *procfileError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("%s: %s", e.file, e.msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+}
+
+var directives = map[string]func(pf *procfile, value string) error{
+	"web": func(pf *procfile, value string) error {
+		if value == "" {
+			return fmt.Errorf("EMPTY web:")
+		}
+		if pf.web == "" {
+			pf.web = value
+		}
+		return nil
+	},
+	"user": func(pf *procfile, value string) error {
+		pf.user = value
+		return nil
+	},
+	"precondition": func(pf *procfile, value string) error {
+		pf.precondition = value
+		return nil
+	},
+	"release": func(pf *procfile, value string) error {
+		pf.release = value
+		return nil
+	},
+	"allow": accessDirective(true),
+	"deny":  accessDirective(false),
+	"fixed-port": func(pf *procfile, value string) (err error) {
+		if pf.fixedPort, err = strconv.Atoi(value); err != nil || pf.fixedPort <= 0 || pf.fixedPort > 65535 {
+			return fmt.Errorf("BAD fixed-port: %s", value)
+		}
+		return nil
+	},
+	"nice": func(pf *procfile, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < -20 || n > 19 {
+			return fmt.Errorf("BAD nice: %s, want -20 to 19", value)
+		}
+		pf.nice = &n
+		return nil
+	},
+	"port-command": func(pf *procfile, value string) error {
+		pf.portCommand = value
+		return nil
+	},
+	"env-command": func(pf *procfile, value string) error {
+		pf.envCommand = value
+		return nil
+	},
+	"rewrite": func(pf *procfile, value string) error {
+		rule, err := parseRewrite(value)
+		if err != nil {
+			return err
+		}
+		pf.rewrites = append(pf.rewrites, rule)
+		return nil
+	},
+	"watch-ext": func(pf *procfile, value string) error {
+		for _, ext := range strings.Split(value, ",") {
+			ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+			if ext == "" || strings.ContainsAny(ext, `/\*`) {
+				return fmt.Errorf("BAD watch-ext: %s, want extensions like go,html", value)
+			}
+			pf.watchExt = append(pf.watchExt, ext)
+		}
+		return nil
+	},
+	"ready": func(pf *procfile, value string) error {
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("BAD ready: %s, want a path like /health", value)
+		}
+		pf.ready = value
+		return nil
+	},
+	"warmup": func(pf *procfile, value string) error {
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("BAD warmup: %s, want a path like /", value)
+		}
+		pf.warmup = value
+		return nil
+	},
+	"boot-timeout":  duration("boot-timeout", func(pf *procfile) *time.Duration { return &pf.bootTimeout }),
+	"ready-timeout": duration("ready-timeout", func(pf *procfile) *time.Duration { return &pf.readyTimeout }),
+	"backoff":       duration("backoff", func(pf *procfile) *time.Duration { return &pf.backoff }),
+	"idle":          duration("idle", func(pf *procfile) *time.Duration { return &pf.idle }),
+	"retry-after":   duration("retry-after", func(pf *procfile) *time.Duration { return &pf.retryAfter }),
+	"max-lifetime":  duration("max-lifetime", func(pf *procfile) *time.Duration { return &pf.maxLifetime }),
+	"reload": func(pf *procfile, value string) error {
+		switch value {
+		case "immediate", "deferred":
+			pf.deferReload = value == "deferred"
+			return nil
+		}
+		return fmt.Errorf("BAD reload: %s, want immediate or deferred", value)
+	},
+	"buffer-request": func(pf *procfile, value string) (err error) {
+		if pf.bufferRequest, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("BAD buffer-request: %s", value)
+		}
+		return nil
+	},
+}
+
+func init() {
+	for _, event := range hookEvents {
+		directives["on-"+event] = func(pf *procfile, value string) error {
+			pf.hooks[event] = value
+			return nil
+		}
+	}
+}
+
+func accessDirective(allow bool) func(pf *procfile, value string) error {
+	return func(pf *procfile, value string) error {
+		rule, err := parseAccessRule(allow, value)
+		if err != nil {
+			return err
+		}
+		pf.access = append(pf.access, rule)
+		return nil
+	}
+}
+
+func duration(name string, field func(pf *procfile) *time.Duration) func(*procfile, string) error {
+	return func(pf *procfile, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("BAD %s: %s", name, value)
+		}
+		*field(pf) = d
+		return nil
+	}
+}
+
+func startScript(dir string) string {
+	for _, name := range startScripts {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && fi.Mode().IsRegular() && isExecutable(fi) {
+			return name
+		}
+	}
+	return ""
+}
+
+var lockfiles = []struct{ file, command string }{
+	{"pnpm-lock.yaml", "pnpm start"},
+	{"yarn.lock", "yarn start"},
+	{"bun.lock", "bun run start"},
+	{"bun.lockb", "bun run start"},
+}
+
+// packageStart returns the command for the start script in package.json,
+// run with the package manager whose lockfile is present, or npm.
+func packageStart(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return ""
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(data, &pkg) != nil || pkg.Scripts["start"] == "" {
+		return ""
+	}
+	for _, l := range lockfiles {
+		if _, err := os.Stat(filepath.Join(dir, l.file)); err == nil {
+			return l.command
+		}
+	}
+	return "npm start"
+}
+
+// defaultCommand is the web command for apps without a Procfile.
+func defaultCommand(dir string) string {
+	if script := startScript(dir); script != "" {
+		return "./" + script + " $PORT"
+	}
+	return packageStart(dir)
+}
+
+func procfilePath(dir string) string {
+	if muxEnv != "" {
+		file := filepath.Join(dir, "Procfile."+muxEnv)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return filepath.Join(dir, "Procfile")
+}
+
+// defaultProcfile applies to apps with neither a Procfile nor a default
+// command, which are then no longer served as static files.
+var defaultProcfile = ""
+
+func isDynamic(dir string) bool {
+	if _, err := os.Stat(procfilePath(dir)); err == nil {
+		return true
+	}
+	return defaultCommand(dir) != "" || defaultProcfile != ""
+}
+
+func parseProcfile(dir string) (*procfile, []*procfileError, error) {
+	pf := &procfile{bootTimeout: defaultBootTimeout, readyTimeout: defaultReadyTimeout, hooks: map[string]string{}}
+	file := procfilePath(dir)
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		if command := defaultCommand(dir); command != "" {
+			pf.web = command
+			return pf, nil, nil
+		}
+		if defaultProcfile != "" {
+			file = defaultProcfile
+			f, err = os.Open(file)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var problems []*procfileError
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			problems = append(problems, &procfileError{file, n, "NO ':' in " + strconv.Quote(line), true})
+			continue
+		}
+		parse, ok := directives[key]
+		if !ok {
+			problems = append(problems, &procfileError{file, n, "UNKNOWN directive " + key + ":", true})
+			continue
+		}
+		if err := parse(pf, strings.TrimSpace(value)); err != nil {
+			problems = append(problems, &procfileError{file, n, err.Error(), false})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	if pf.web == "" {
+		problems = append(problems, &procfileError{file, 0, "NO web:", false})
+	}
+	return pf, problems, nil
+}
+
+func readProcfile(dir string) (*procfile, error) {
+	pf, problems, err := parseProcfile(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range problems {
+		if !p.warn {
+			return nil, p
+		}
+	}
+	return pf, nil
+}
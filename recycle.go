@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// recycleWait is how long recycle waits for a moment without requests
+// before it reloads the app anyway.
+const recycleWait = time.Minute
+
+// recycle reloads app once it has run for its max-lifetime:, preferably
+// while no requests are in flight. The reload swaps in a new instance the
+// same way a file change does.
+func recycle(app *appInfo) {
+	select {
+	case <-time.After(app.pf.maxLifetime):
+	case <-app.exited:
+		return
+	}
+	deadline := time.Now().Add(recycleWait)
+	for app.active.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	mu.Lock()
+	stopping := app.stopping
+	mu.Unlock()
+	if !stopping {
+		infof("RECYCLE: %s after %s", app.name, app.pf.maxLifetime)
+		reloadApp(app, "max-lifetime")
+	}
+}
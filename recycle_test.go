@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecycle(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "leaky", "max-lifetime: 300ms\n")
+	began := time.Now()
+	get("leaky.localhost", "/")
+	mu.Lock()
+	first := apps["leaky"]
+	mu.Unlock()
+
+	// A request in flight when the lifetime ends delays the recycle.
+	time.Sleep(200 * time.Millisecond)
+	served := make(chan int)
+	go func() { served <- get("leaky.localhost", "/sleep/300ms").Code }()
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	current := apps["leaky"]
+	mu.Unlock()
+	if current != first {
+		t.Fatal("recycled while a request was in flight")
+	}
+	if code := <-served; code != http.StatusOK {
+		t.Errorf("request during the recycle: status %d", code)
+	}
+	waitFor(t, "the recycle", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["leaky"] != first && apps["leaky"] != nil
+	})
+	if took := time.Since(began); took < 500*time.Millisecond {
+		t.Errorf("recycled after %s, before the request ended", took)
+	}
+	select {
+	case <-first.exited:
+	case <-time.After(5 * time.Second):
+		t.Error("old instance still running after the recycle")
+	}
+}
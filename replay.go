@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// captureApps keeps the last request of each app in memory so -replay can
+// send it again. Bodies are kept up to captureSize bytes.
+var (
+	captureApps = map[string]bool{}
+	captureSize = 64 << 10
+
+	capturedMu sync.Mutex
+	captured   = map[string]*capturedRequest{}
+)
+
+type capturedRequest struct {
+	method, uri, host, remote string
+	header                    http.Header
+	body                      []byte
+}
+
+// capture records r for name, leaving r's body intact for the proxy.
+func capture(name string, r *http.Request) {
+	if !captureApps[name] && !captureApps["*"] {
+		return
+	}
+	c := &capturedRequest{method: r.Method, uri: r.URL.RequestURI(), host: r.Host, remote: r.RemoteAddr, header: r.Header.Clone()}
+	if r.Body != nil && r.Body != http.NoBody {
+		c.body, _ = io.ReadAll(io.LimitReader(r.Body, int64(captureSize)))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(c.body), r.Body), r.Body}
+	}
+	capturedMu.Lock()
+	captured[name] = c
+	capturedMu.Unlock()
+}
+
+// replayHandler sends the captured request through the proxy again and
+// answers with the app's response.
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := adminApp(w, r)
+	if !ok {
+		return
+	}
+	capturedMu.Lock()
+	c := captured[name]
+	capturedMu.Unlock()
+	if c == nil {
+		writeAdminError(w, http.StatusNotFound, "not_captured", "NO request captured for "+name+", run mux with -capture")
+		return
+	}
+	req, err := http.NewRequest(c.method, "http://"+c.host+c.uri, bytes.NewReader(c.body))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	req.Header, req.Host, req.RemoteAddr = c.header.Clone(), c.host, c.remote
+	req.Header.Set("X-Mux-Replay", "1")
+	infof("REPLAY: %s %s %s", name, c.method, c.uri)
+	handler(w, req)
+}
+
+func runReplay(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("USAGE: mux -replay APP")
+	}
+	return adminRequest(http.MethodPost, "/apps/"+args[0]+"/replay", nil)
+}
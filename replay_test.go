@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	testApp(t, root, "other", "")
+	testAdminSocket(t)
+	captureApps = map[string]bool{"api": true}
+	defer func() { captureApps = map[string]bool{} }()
+	capturedMu.Lock()
+	clear(captured)
+	capturedMu.Unlock()
+
+	if err := runReplay([]string{"api"}); err == nil || !strings.Contains(err.Error(), "NO request captured for api") {
+		t.Errorf("replay before a request: %v", err)
+	}
+	r := httptest.NewRequest("POST", "http://api.localhost/echo", strings.NewReader("hello"))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Body.String() != "hello" {
+		t.Fatalf("captured request: %d %q", w.Code, w.Body)
+	}
+	get("other.localhost", "/")
+
+	var err error
+	out := stdout(t, func() { err = runReplay([]string{"api"}) })
+	if err != nil || out != "hello" {
+		t.Errorf("mux -replay api: %v %q, want the body echoed again", err, out)
+	}
+	if w := admin("POST", "/apps/api/replay", ""); w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("replayed Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+	if w := admin("POST", "/apps/other/replay", ""); w.Code != http.StatusNotFound || adminCode(w) != "not_captured" {
+		t.Errorf("app without -capture: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCaptureKeepsBody(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	captureApps, captureSize = map[string]bool{"*": true}, 4
+	defer func() { captureApps, captureSize = map[string]bool{}, 64<<10 }()
+	if w := upload("api.localhost", "/echo", "hello world"); w.Body.String() != "hello world" {
+		t.Errorf("app got %q past the capture cap, want the whole body", w.Body)
+	}
+	capturedMu.Lock()
+	c := captured["api"]
+	capturedMu.Unlock()
+	if c == nil || string(c.body) != "hell" {
+		t.Errorf("captured %+v, want the first 4 bytes", c)
+	}
+}
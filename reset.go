@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// evictOnReset stops an app whose connection resets mid-request and that no
+// longer serves, so the next request starts it fresh instead of waiting for
+// the idle reaper.
+var evictOnReset = true
+
+// detectResets wraps app's proxy to notice the backend dropping the
+// connection. Before the response starts, ErrorHandler still answers 502;
+// after, the client sees an aborted response and mux logs the reset. A bare
+// EOF before the response is not a reset: it is how a request on a
+// keep-alive connection the backend just closed fails.
+func detectResets(app *appInfo) {
+	handleError := app.p.ErrorHandler
+	app.p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if isReset(err) && r.Context().Err() == nil {
+			backendReset(app, r, err)
+		}
+		handleError(w, r, err)
+	}
+	modify := app.p.ModifyResponse
+	app.p.ModifyResponse = func(resp *http.Response) error {
+		if modify != nil {
+			if err := modify(resp); err != nil {
+				return err
+			}
+		}
+		// An upgraded connection's body must stay an io.ReadWriteCloser.
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			resp.Body = &resetBody{ReadCloser: resp.Body, app: app, r: resp.Request}
+		}
+		return nil
+	}
+}
+
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+func backendReset(app *appInfo, r *http.Request, err error) {
+	warnf("RESET: %s %s %s: %v", app.name, r.Method, r.URL.Path, err)
+	if evictOnReset {
+		go func() {
+			// A process on its way out is a crash for watchExit, which runs
+			// the crash hook; evicting it first would pass for a stop.
+			select {
+			case <-app.exited:
+				return
+			case <-time.After(resetGrace):
+			}
+			if backendGone(app) {
+				stopApp(app)
+			}
+		}()
+	}
+}
+
+// resetGrace is how long a backend that reset a connection has to exit
+// before mux checks whether it still serves.
+const resetGrace = 200 * time.Millisecond
+
+// backendGone reports whether app no longer serves: its process exited, or
+// nothing listens on its port, as when a start script outlives its server.
+func backendGone(app *appInfo) bool {
+	if app.exited != nil && isExited(app.exited) {
+		return true
+	}
+	_, ok := dialLoopback(app.port)
+	return !ok
+}
+
+// resetBody reports a response body that fails mid-read, with a reset or a
+// truncated body alike, unless the client went away first.
+type resetBody struct {
+	io.ReadCloser
+	app  *appInfo
+	r    *http.Request
+	once sync.Once
+}
+
+func (b *resetBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.r.Context().Err() == nil {
+		b.once.Do(func() { backendReset(b.app, b.r, err) })
+	}
+	return n, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackendReset(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "crashy", "")
+	buf := captureLog(t, levelWarn)
+
+	if w := get("crashy.localhost", "/reset"); w.Code != http.StatusBadGateway {
+		t.Errorf("reset before the response: status %d, want 502", w.Code)
+	}
+	if !strings.Contains(buf.String(), "RESET: crashy GET /reset: ") {
+		t.Errorf("logged %q, want the reset", buf)
+	}
+	mu.Lock()
+	app := apps["crashy"]
+	mu.Unlock()
+	if app == nil {
+		t.Fatal("evicted an app that still serves")
+	}
+
+	w := get("crashy.localhost", "/crash")
+	if w.Body.String() != "partial" {
+		t.Errorf("crash mid-body: got %q", w.Body)
+	}
+	if !strings.Contains(buf.String(), "RESET: crashy GET /crash: ") {
+		t.Errorf("logged %q, want the truncated body", buf)
+	}
+	waitFor(t, "the crashed app to be evicted", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["crashy"] != app
+	})
+	if w := get("crashy.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("after the crash: status %d, want a fresh start", w.Code)
+	}
+}
+
+// upgrade switches a connection to host through handler to the echo
+// protocol of testBackend and checks data comes back.
+func upgrade(t *testing.T, host string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: "+host+"\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("%s: upgrade got %s, want 101", host, resp.Status)
+	}
+	io.WriteString(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("%s: echoed %q, %v", host, buf, err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "socket", "")
+	testApp(t, root, "dumped", "")
+	dumpApps["dumped"] = true
+	defer delete(dumpApps, "dumped")
+	upgrade(t, "socket.localhost")
+	upgrade(t, "dumped.localhost")
+}
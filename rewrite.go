@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+type rewriteRule struct {
+	strip  bool
+	prefix string
+}
+
+func parseRewrite(value string) (rewriteRule, error) {
+	op, prefix, _ := strings.Cut(value, " ")
+	prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+	if (op != "strip" && op != "add") || !strings.HasPrefix(prefix, "/") {
+		return rewriteRule{}, fmt.Errorf("BAD rewrite: %s, want strip /PREFIX or add /PREFIX", value)
+	}
+	return rewriteRule{op == "strip", prefix}, nil
+}
+
+func (rule rewriteRule) apply(path string) string {
+	if !rule.strip {
+		return rule.prefix + path
+	}
+	if path == rule.prefix {
+		return "/"
+	}
+	if strings.HasPrefix(path, rule.prefix+"/") {
+		return path[len(rule.prefix):]
+	}
+	return path
+}
+
+// rewritePaths applies the rewrite: rules in order to the path the app sees.
+func rewritePaths(proxy *httputil.ReverseProxy, rules []rewriteRule) {
+	if len(rules) == 0 {
+		return
+	}
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		for _, rule := range rules {
+			r.URL.Path = rule.apply(r.URL.Path)
+		}
+		r.URL.RawPath = ""
+	}
+}
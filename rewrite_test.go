@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestRewriteRule(t *testing.T) {
+	strip, err := parseRewrite("strip /api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	add, err := parseRewrite("add /app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		rule       rewriteRule
+		path, want string
+	}{
+		{strip, "/api/users", "/users"},
+		{strip, "/api", "/"},
+		{strip, "/apiary", "/apiary"},
+		{strip, "/other", "/other"},
+		{add, "/users", "/app/users"},
+	} {
+		if got := c.rule.apply(c.path); got != c.want {
+			t.Errorf("%+v.apply(%q) = %q, want %q", c.rule, c.path, got, c.want)
+		}
+	}
+	for _, value := range []string{"strip", "strip api", "move /api"} {
+		if _, err := parseRewrite(value); err == nil {
+			t.Errorf("parseRewrite(%q) accepted", value)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "mounted", "rewrite: strip /api\n")
+	testApp(t, root, "nested", "rewrite: add /path\n")
+	if w := get("mounted.localhost", "/api/path/users"); w.Body.String() != "/path/users" {
+		t.Errorf("strip: backend saw %q, want /path/users", w.Body)
+	}
+	if w := get("nested.localhost", "/users"); w.Body.String() != "/path/users" {
+		t.Errorf("add: backend saw %q, want /path/users", w.Body)
+	}
+}
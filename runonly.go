@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// runOnly runs the app name in the foreground for -run-only: with its .env
+// files and watching, but no proxy or service. A reload swaps in a new
+// instance as usual; otherwise runOnly returns the app's exit code once it
+// exits, or 0 after stopping it when mux is interrupted.
+func runOnly(name string) (int, error) {
+	dir, err := appDir(name)
+	if err != nil {
+		return 0, err
+	}
+	if !isDir(dir) || !isDynamic(dir) {
+		return 0, fmt.Errorf("NOT a dynamic app: %s", dir)
+	}
+	foreground = true
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	var app *appInfo
+	for {
+		if app == nil {
+			if app, err = start(name); err != nil {
+				return 0, err
+			}
+			mu.Lock()
+			apps[name] = app
+			mu.Unlock()
+		}
+		infof("RUNNING: %s at http://%s", name, net.JoinHostPort(app.host, strconv.Itoa(app.port)))
+		select {
+		case <-sig:
+			stopApp(app)
+			<-app.exited
+			return 0, nil
+		case <-app.exited:
+		}
+		mu.Lock()
+		// watchExit may have stopped a crashed app already, which crashed
+		// tells apart from a stop for a reload.
+		crashed := !app.stopping || app.crashed
+		next := apps[name]
+		mu.Unlock()
+		switch {
+		case crashed:
+			return app.c.ProcessState.ExitCode(), nil
+		case next != nil && next != app:
+			app = next
+		default:
+			// A fixed-port: app stops to reload and starts again here.
+			app = nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRunOnly(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "only", "")
+	testApp(t, root, "other", "")
+	writeFiles(t, root, map[string]string{"site/index.html": "static"})
+	defer func() { foreground = false }()
+	defer signal.Reset(os.Interrupt, syscall.SIGTERM)
+
+	if _, err := runOnly("site"); err == nil {
+		t.Error("runOnly ran a static app")
+	}
+	var code int
+	var err error
+	out := stdout(t, func() {
+		done := make(chan struct{})
+		go func() {
+			code, err = runOnly("only")
+			close(done)
+		}()
+		waitFor(t, "the app", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return apps["only"] != nil
+		})
+		get("only.localhost", "/print/passed-through")
+		mu.Lock()
+		_, other := apps["other"]
+		mu.Unlock()
+		if other {
+			t.Error("runOnly started another app")
+		}
+		get("only.localhost", "/crash")
+		<-done
+	})
+	if err != nil || code != 1 {
+		t.Errorf("runOnly = %d, %v, want the app's exit code 1", code, err)
+	}
+	if !strings.Contains(out, "passed-through\n") {
+		t.Errorf("app output %q not passed through", out)
+	}
+}
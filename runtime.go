@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type runtimeState struct {
+	PID     int       `json:"pid"`
+	Port    int       `json:"port"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func runtimeFile(dir string) string {
+	return filepath.Join(dir, ".mux", "runtime.json")
+}
+
+func saveRuntime(app *appInfo, cmdStr string) error {
+	data, err := json.Marshal(runtimeState{
+		PID:     app.c.Process.Pid,
+		Port:    app.port,
+		Command: cmdStr,
+		Started: app.t,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(runtimeFile(app.dir)), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(runtimeFile(app.dir), data, 0644)
+}
+
+// removeRuntime removes the runtime file unless it belongs to a newer
+// instance of the app.
+func removeRuntime(app *appInfo) {
+	data, err := os.ReadFile(runtimeFile(app.dir))
+	if err != nil {
+		return
+	}
+	var rt runtimeState
+	if json.Unmarshal(data, &rt) == nil && rt.PID != app.c.Process.Pid {
+		return
+	}
+	_ = os.Remove(runtimeFile(app.dir))
+}
+
+// pidFile holds the PID of an app's server for other tools. Where
+// listenerPID can't find it, it holds the PID of the web command instead,
+// which on Unix is also the process group, so signaling its negation still
+// reaches the server.
+func pidFile(dir string) string {
+	return filepath.Join(dir, ".mux", "pid")
+}
+
+func savePID(app *appInfo) error {
+	if app.serverPID = listenerPID(app.port); app.serverPID == 0 {
+		app.serverPID = app.c.Process.Pid
+	}
+	return os.WriteFile(pidFile(app.dir), []byte(strconv.Itoa(app.serverPID)+"\n"), 0644)
+}
+
+// removePID removes the PID file unless it belongs to a newer instance.
+func removePID(app *appInfo) {
+	data, err := os.ReadFile(pidFile(app.dir))
+	if err != nil {
+		return
+	}
+	if pid, _ := strconv.Atoi(strings.TrimSpace(string(data))); pid != app.serverPID && pid != app.c.Process.Pid {
+		return
+	}
+	_ = os.Remove(pidFile(app.dir))
+}
+
+func readRuntime(dir string) (runtimeState, error) {
+	var rt runtimeState
+	data, err := os.ReadFile(runtimeFile(dir))
+	if err != nil {
+		return rt, err
+	}
+	return rt, json.Unmarshal(data, &rt)
+}
+
+func adoptApp(name, dir string) *appInfo {
+	rt, err := readRuntime(dir)
+	if err != nil {
+		return nil
+	}
+	proc, err := findProcess(rt.PID)
+	var host string
+	if err == nil {
+		host, err = waitPort(rt.Port, 200*time.Millisecond, nil)
+	}
+	if err != nil {
+		_ = os.Remove(runtimeFile(dir))
+		return nil
+	}
+	pf, err := readProcfile(dir)
+	if err != nil {
+		pf = &procfile{web: rt.Command}
+	}
+	debugf("ADOPT: %s PID=%d PORT=%d", name, rt.PID, rt.Port)
+	app := newAppInfo(name, dir, pf, host, rt.Port, &exec.Cmd{Process: proc})
+	app.exited, app.serverPID = make(chan struct{}), listenerPID(rt.Port)
+	go awaitExit(proc, app.exited)
+	go watchExit(app)
+	return app
+}
+
+const adoptedPoll = time.Second
+
+// awaitExit closes exited when an adopted process ends. After a SIGUSR2
+// re-exec it is still mux's child and can be waited for; after a restart
+// it isn't, and is polled instead.
+func awaitExit(p *os.Process, exited chan struct{}) {
+	defer close(exited)
+	if _, err := p.Wait(); err == nil {
+		return
+	}
+	for range time.Tick(adoptedPoll) {
+		if _, err := findProcess(p.Pid); err != nil {
+			return
+		}
+	}
+}
+
+// inherited are the listeners a re-executing mux handed over in
+// MUX_LISTEN_FDS as ADDR=FD pairs.
+var (
+	inheritOnce sync.Once
+	inherited   = map[string]int{}
+)
+
+func listen(addr string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		for _, pair := range strings.Split(os.Getenv("MUX_LISTEN_FDS"), ",") {
+			a, fd, _ := strings.Cut(pair, "=")
+			if n, err := strconv.Atoi(fd); err == nil {
+				inherited[a] = n
+			}
+		}
+		os.Unsetenv("MUX_LISTEN_FDS")
+	})
+	if n, ok := inherited[addr]; ok {
+		delete(inherited, addr)
+		// FileListener dups the descriptor; close ours rather than leave
+		// it to a finalizer that may close whatever reuses the number.
+		f := os.NewFile(uintptr(n), "listener")
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// served are the servers handleRestart shuts down and whose listeners it
+// hands to the next mux.
+var (
+	servedMu sync.Mutex
+	served   = map[string]servedListener{}
+)
+
+type servedListener struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// serve serves srv on addr, with TLS if it has a TLSConfig, until mux
+// exits. Once handleRestart has shut srv down it waits for the exec.
+func serve(srv *http.Server, addr string) {
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	servedMu.Lock()
+	served[addr] = servedListener{srv, ln}
+	servedMu.Unlock()
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	select {}
+}
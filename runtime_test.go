@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAdoptApp(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "kept", "")
+	old, err := getApp("kept", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new mux finds the app in .mux/runtime.json, not in apps.
+	mu.Lock()
+	delete(apps, "kept")
+	mu.Unlock()
+	stopWatcher(old)
+
+	app := adoptApp("kept", dir)
+	if app == nil {
+		t.Fatal("running app not adopted")
+	}
+	mu.Lock()
+	apps["kept"] = app
+	mu.Unlock()
+	if app.port != old.port || app.c.Process.Pid != old.c.Process.Pid {
+		t.Errorf("adopted PID %d PORT %d, want %d and %d", app.c.Process.Pid, app.port, old.c.Process.Pid, old.port)
+	}
+	if w := get("kept.localhost", "/"); w.Code != http.StatusOK {
+		t.Errorf("status %d: %s", w.Code, w.Body)
+	}
+
+	// The adopted process crashing stops the app.
+	if err := old.c.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	<-app.exited
+	waitFor(t, "the crash to stop the app", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["kept"] == nil
+	})
+	if adoptApp("kept", dir) != nil {
+		t.Error("exited app adopted")
+	}
+}
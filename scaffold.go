@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// scaffolds maps each -type to the files -new writes. Static apps get no
+// Procfile, which would make them dynamic, nor a .env they would serve.
+var scaffolds = map[string]map[string]string{
+	"node": {
+		"Procfile": "web: node index.js\n",
+		".watch":   "*.js\n*.json\n!node_modules/\n",
+		".env":     envStub,
+		"index.js": "require('http').createServer((req, res) => res.end('hello\\n')).listen(process.env.PORT)\n",
+	},
+	"go": {
+		"Procfile": "web: go run .\n",
+		".watch":   "*.go\ngo.mod\ngo.sum\n",
+		".env":     envStub,
+		"go.mod":   "module app\n\ngo 1.22\n",
+		"main.go": "package main\n\nimport (\n\t\"fmt\"\n\t\"net/http\"\n\t\"os\"\n)\n\n" +
+			"func main() {\n\thttp.HandleFunc(\"/\", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintln(w, \"hello\") })\n" +
+			"\thttp.ListenAndServe(\":\"+os.Getenv(\"PORT\"), nil)\n}\n",
+	},
+	"static": {
+		"index.html": "<!doctype html>\n<title>hello</title>\n<p>hello</p>\n",
+	},
+}
+
+const envStub = "# KEY=value lines set env for the app, .env.local overrides them\n"
+
+// newApp creates the app name in the first -dir root with the files for
+// kind. It refuses an app that already exists in any root.
+func newApp(name, kind string) error {
+	files, ok := scaffolds[kind]
+	if !ok {
+		kinds := make([]string, 0, len(scaffolds))
+		for k := range scaffolds {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		return fmt.Errorf("BAD -type %q, want %s", kind, strings.Join(kinds, ", "))
+	}
+	dir, err := appDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("EXISTS: %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for file, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("CREATED: %s, visit http://%s.%s\n", dir, name, domain)
+	return nil
+}
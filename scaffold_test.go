@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewApp(t *testing.T) {
+	root := testRoot(t)
+	var err error
+	out := stdout(t, func() { err = newApp("api", "node") })
+	if err != nil || !strings.Contains(out, "visit http://api.localhost") {
+		t.Fatalf("newApp: %v: %s", err, out)
+	}
+	for file, want := range scaffolds["node"] {
+		data, err := os.ReadFile(filepath.Join(root, "api", file))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", file, data, err, want)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "api", "Procfile")); string(data) != "web: node index.js\n" {
+		t.Errorf("node Procfile = %q", data)
+	}
+
+	stdout(t, func() { err = newApp("site", "static") })
+	if err != nil || isDynamic(filepath.Join(root, "site")) {
+		t.Errorf("static app: %v, dynamic %v", err, isDynamic(filepath.Join(root, "site")))
+	}
+	if err := newApp("api", "go"); err == nil || !strings.HasPrefix(err.Error(), "EXISTS") {
+		t.Errorf("existing app: %v", err)
+	}
+	if err := newApp("web", "php"); err == nil || !strings.Contains(err.Error(), "go, node, static") {
+		t.Errorf("unknown type: %v", err)
+	}
+	if err := newApp("../up", "go"); err == nil {
+		t.Error("newApp accepted a path")
+	}
+}
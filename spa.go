@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// spa serves index.html for client-side routes in static apps: paths with
+// no file behind them and no extension other than .html, so missing assets
+// still 404.
+var spa = false
+
+// serveStatic answers OPTIONS itself, where FileServer would send the file,
+// and HEAD with the headers of GET but no body.
+func serveStatic(w http.ResponseWriter, r *http.Request, dir string) {
+	if r.Method == http.MethodOptions {
+		serveOptions(w, staticMethods)
+		return
+	}
+	setCacheHeaders(w, r, dir)
+	if spa && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		p := path.Clean("/" + r.URL.Path)
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); os.IsNotExist(err) {
+			if ext := path.Ext(p); ext == "" || ext == ".html" {
+				http.ServeFile(w, r, filepath.Join(dir, "index.html"))
+				return
+			}
+		}
+	}
+	http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+}
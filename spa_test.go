@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSPA(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{"client/index.html": "shell", "client/app.css": "css"})
+	if w := get("client.localhost", "/users/42"); w.Code != http.StatusNotFound {
+		t.Errorf("without -spa: %d, want 404", w.Code)
+	}
+	spa = true
+	defer func() { spa = false }()
+	for path, want := range map[string]struct {
+		code int
+		body string
+	}{
+		"/users/42":     {http.StatusOK, "shell"},
+		"/about.html":   {http.StatusOK, "shell"},
+		"/app.css":      {http.StatusOK, "css"},
+		"/missing.js":   {http.StatusNotFound, ""},
+		"/img/logo.png": {http.StatusNotFound, ""},
+	} {
+		w := get("client.localhost", path)
+		if w.Code != want.code || want.body != "" && w.Body.String() != want.body {
+			t.Errorf("%s: %d %q, want %d %q", path, w.Code, w.Body, want.code, want.body)
+		}
+	}
+}
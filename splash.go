@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// bootLogPath streams an app's boot output to the splash page while
+// -async-start boots it.
+const bootLogPath = "/.mux/boot-log"
+
+// bootLog keeps the output of an app's release and web commands while it
+// boots and passes new lines to splash pages following it.
+type bootLog struct {
+	mu    sync.Mutex
+	lines []string
+	part  string
+	subs  map[chan string]bool
+	err   error
+	done  bool
+}
+
+const bootLogLines = 200
+
+var (
+	bootLogsMu sync.Mutex
+	bootLogs   = map[string]*bootLog{}
+)
+
+func beginBoot(name string) *bootLog {
+	bl := &bootLog{subs: map[chan string]bool{}}
+	bootLogsMu.Lock()
+	bootLogs[name] = bl
+	bootLogsMu.Unlock()
+	return bl
+}
+
+func endBoot(name string, bl *bootLog, err error) {
+	if bl == nil {
+		return
+	}
+	bootLogsMu.Lock()
+	if bootLogs[name] == bl {
+		delete(bootLogs, name)
+	}
+	bootLogsMu.Unlock()
+	bl.mu.Lock()
+	bl.done, bl.err, bl.lines = true, err, nil
+	for c := range bl.subs {
+		close(c)
+	}
+	bl.subs = nil
+	bl.mu.Unlock()
+}
+
+// bootWriter is where name's commands also write while it boots.
+func bootWriter(name string) *bootLog {
+	bootLogsMu.Lock()
+	defer bootLogsMu.Unlock()
+	return bootLogs[name]
+}
+
+func (bl *bootLog) Write(p []byte) (int, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.done {
+		return len(p), nil
+	}
+	lines := strings.Split(bl.part+string(p), "\n")
+	bl.part = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if bl.lines = append(bl.lines, line); len(bl.lines) > bootLogLines {
+			bl.lines = bl.lines[1:]
+		}
+		for c := range bl.subs {
+			select {
+			case c <- line:
+			default:
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// follow returns the lines so far and a channel for the next ones, closed
+// when the boot ends.
+func (bl *bootLog) follow() ([]string, chan string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	c := make(chan string, 64)
+	if bl.done {
+		close(c)
+	} else {
+		bl.subs[c] = true
+	}
+	return append([]string(nil), bl.lines...), c
+}
+
+func (bl *bootLog) unfollow(c chan string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	delete(bl.subs, c)
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+var splashPage = template.Must(template.New("splash").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>Starting {{.}}</title></head>
+<body style="font-family: sans-serif; margin: 3em">
+<h1>Starting {{.}}…</h1>
+<pre id="log" style="background: #f4f4f4; padding: 1em; max-height: 60vh; overflow: auto"></pre>
+<script>
+var log = document.getElementById("log");
+var es = new EventSource("/.mux/boot-log");
+es.onmessage = function(e) { log.textContent += e.data + "\n"; log.scrollTop = log.scrollHeight; };
+es.addEventListener("ready", function() { es.close(); location.reload(); });
+es.addEventListener("failed", function(e) { es.close(); log.textContent += "\nFAILED: " + e.data + "\n"; });
+</script>
+</body>
+</html>
+`))
+
+// serveSplash answers a browser waiting for name to boot with a page that
+// follows the boot log and reloads once the app is up.
+func serveSplash(w http.ResponseWriter, name string) {
+	var buf bytes.Buffer
+	if err := splashPage.Execute(&buf, name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// serveBootLog streams name's boot output as server-sent events, ending with
+// a ready or failed event.
+func serveBootLog(w http.ResponseWriter, r *http.Request, name string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "NO streaming", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	bl := bootWriter(name)
+	if bl == nil {
+		fmt.Fprint(w, "event: ready\ndata:\n\n")
+		return
+	}
+	lines, c := bl.follow()
+	defer bl.unfollow(c)
+	for _, line := range lines {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case line, ok := <-c:
+			if !ok {
+				bl.mu.Lock()
+				err := bl.err
+				bl.mu.Unlock()
+				if err != nil {
+					fmt.Fprintf(w, "event: failed\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+				} else {
+					fmt.Fprint(w, "event: ready\ndata:\n\n")
+				}
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", line)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
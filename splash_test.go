@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplash(t *testing.T) {
+	needShell(t)
+	root := testRoot(t)
+	testApp(t, root, "booting", "release: echo building; sleep 0.5\n")
+	asyncStart = true
+	defer func() { asyncStart = false }()
+
+	r := httptest.NewRequest("GET", "http://booting.localhost/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "Starting booting") || !strings.Contains(w.Body.String(), bootLogPath) {
+		t.Errorf("during boot: %d %s, want the splash page", w.Code, w.Body)
+	}
+
+	waitFor(t, "the boot to begin", func() bool { return bootWriter("booting") != nil })
+	w = get("booting.localhost", bootLogPath)
+	if w.Header().Get("Content-Type") != "text/event-stream" || !strings.Contains(w.Body.String(), "data: building\n\n") || !strings.HasSuffix(w.Body.String(), "event: ready\ndata:\n\n") {
+		t.Errorf("boot log: %q, want the release output then ready", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "PORT=") {
+		t.Errorf("after boot: %d %s, want the app", w.Code, w.Body)
+	}
+}
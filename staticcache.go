@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheRule gives static files matching pattern a max-age. A pattern with a
+// slash matches the path like allow: rules do, so /assets/* covers everything
+// below; one without matches the file name, like *.css.
+type cacheRule struct {
+	pattern string
+	maxAge  time.Duration
+}
+
+var staticCache []cacheRule
+
+func parseStaticCache(spec string) ([]cacheRule, error) {
+	var rules []cacheRule
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		pattern, age, ok := strings.Cut(item, "=")
+		d, err := time.ParseDuration(age)
+		if !ok || err != nil || d < 0 {
+			return nil, fmt.Errorf("BAD -static-cache %q, want PATTERN=DURATION", item)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("BAD -static-cache %q: %v", item, err)
+		}
+		rules = append(rules, cacheRule{pattern, d})
+	}
+	return rules, nil
+}
+
+func (rule cacheRule) matches(p string) bool {
+	if !strings.Contains(rule.pattern, "/") {
+		ok, _ := path.Match(rule.pattern, path.Base(p))
+		return ok
+	}
+	if prefix, ok := strings.CutSuffix(rule.pattern, "/*"); ok {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	ok, _ := path.Match(rule.pattern, p)
+	return ok
+}
+
+// setCacheHeaders adds Cache-Control and an ETag, which http.FileServer
+// then answers If-None-Match with, for the first -static-cache rule matching
+// r. Headers already set are kept.
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, dir string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	for _, rule := range staticCache {
+		if !rule.matches(p) {
+			continue
+		}
+		h := w.Header()
+		if h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(rule.maxAge.Seconds())))
+		}
+		if h.Get("ETag") == "" {
+			file := filepath.Join(dir, filepath.FromSlash(p))
+			if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+				file = filepath.Join(file, "index.html")
+			}
+			if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+				h.Set("ETag", fmt.Sprintf(`W/"%x-%x"`, fi.Size(), fi.ModTime().UnixNano()))
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticCache(t *testing.T) {
+	root := testRoot(t)
+	writeFiles(t, root, map[string]string{
+		"site/assets/app.js": "js",
+		"site/style.css":     "css",
+		"site/notes.txt":     "txt",
+	})
+	var err error
+	if staticCache, err = parseStaticCache("/assets/*=24h, *.css=1h, *=0s"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { staticCache = nil }()
+	for path, want := range map[string]string{
+		"/assets/app.js": "public, max-age=86400",
+		"/style.css":     "public, max-age=3600",
+		"/notes.txt":     "public, max-age=0",
+	} {
+		if w := get("site.localhost", path); w.Header().Get("Cache-Control") != want || w.Header().Get("ETag") == "" {
+			t.Errorf("%s: Cache-Control %q ETag %q, want %q and an ETag", path, w.Header().Get("Cache-Control"), w.Header().Get("ETag"), want)
+		}
+	}
+
+	etag := get("site.localhost", "/style.css").Header().Get("ETag")
+	r := httptest.NewRequest("GET", "http://site.localhost/style.css", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match the ETag: %d, want 304", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	w.Header().Set("Cache-Control", "no-store")
+	setCacheHeaders(w, httptest.NewRequest("GET", "/style.css", nil), root+"/site")
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("replaced Cache-Control no-store with %q", got)
+	}
+	for _, spec := range []string{"*.css", "*.css=soon", "[=1h"} {
+		if _, err := parseStaticCache(spec); err == nil {
+			t.Errorf("parseStaticCache(%q) accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type appStatus struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Dir     string `json:"dir"`
+	DocRoot string `json:"doc_root,omitempty"`
+	Running bool   `json:"running"`
+	PID     int    `json:"pid,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// ServerPID listens on Port; it differs from PID when the command is a
+	// script that runs the server without exec.
+	ServerPID int `json:"server_pid,omitempty"`
+
+	Degraded string `json:"degraded,omitempty"`
+	Failing  bool   `json:"failing,omitempty"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+func statusOf(name string) (*appStatus, error) {
+	dir, err := appDir(name)
+	if err != nil {
+		return nil, err
+	}
+	st := &appStatus{Name: name, Type: "static", Dir: dir}
+	if !isDynamic(dir) {
+		st.DocRoot = dir
+		return st, nil
+	}
+	st.Type = "dynamic"
+	mu.Lock()
+	if a := apps[name]; a != nil {
+		st.Running, st.PID, st.Port, st.Degraded, st.Command = true, a.c.Process.Pid, a.port, a.degraded, a.pf.web
+	}
+	failed, hasFailed := failures[name]
+	mu.Unlock()
+	if !st.Running && hasFailed {
+		st.Failing = inBackoff(dir, failed)
+	}
+	if st.Running {
+		if st.ServerPID = listenerPID(st.Port); st.ServerPID == 0 {
+			st.ServerPID = st.PID
+		}
+	}
+	return st, nil
+}
+
+// inBackoff reports whether an app that failed to start at failed still
+// refuses to start again because of its Procfile backoff:.
+func inBackoff(dir string, failed time.Time) bool {
+	pf, err := readProcfile(dir)
+	return err == nil && time.Since(failed) < pf.backoff
+}
+
+// healthSummary counts apps for monitors; failing apps are in backoff: after
+// a failed start, degraded ones serve an old version after a failed reload.
+type healthSummary struct {
+	Status   string `json:"status"`
+	Apps     int    `json:"apps"`
+	Running  int    `json:"running"`
+	Failing  int    `json:"failing"`
+	Degraded int    `json:"degraded"`
+}
+
+// healthSummaryHandler answers 503 unless every app is ok, so a monitor can
+// go by the status code alone.
+func healthSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	names, _, err := cachedScan()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	sum := healthSummary{Status: "ok"}
+	for _, name := range names {
+		st, err := statusOf(name)
+		if err != nil {
+			continue
+		}
+		sum.Apps++
+		if st.Running {
+			sum.Running++
+		}
+		if st.Failing {
+			sum.Failing++
+		}
+		if st.Degraded != "" {
+			sum.Degraded++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if sum.Failing > 0 || sum.Degraded > 0 {
+		sum.Status = "degraded"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(sum)
+}
+
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// statusHandler lists apps, or with ?offset=N&limit=N one page of them, and
+// the total in X-Total-Count.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, -1
+	for key, n := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if s := r.URL.Query().Get(key); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				writeAdminError(w, http.StatusBadRequest, "bad_request", "BAD "+key+" "+s)
+				return
+			}
+			*n = v
+		}
+	}
+	names, conflicts, err := cachedScan()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(names)))
+	names = names[min(offset, len(names)):]
+	if limit >= 0 && limit < len(names) {
+		names = names[:limit]
+	}
+	list := []*appStatus{}
+	for _, name := range names {
+		if st, err := statusOf(name); err == nil {
+			st.Conflict = conflicts[name]
+			list = append(list, st)
+		}
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "APP\tTYPE\tSTATE\tPID\tPORT\tDIR")
+	for _, st := range list {
+		state, pid, port := "-", "-", "-"
+		if st.Type == "dynamic" {
+			state = "stopped"
+		}
+		if st.Running {
+			state, pid, port = "running", fmt.Sprint(st.PID), fmt.Sprint(st.Port)
+			if st.ServerPID != st.PID {
+				pid += "/" + fmt.Sprint(st.ServerPID)
+			}
+		}
+		if st.Degraded != "" {
+			state = "degraded"
+		}
+		if st.Failing {
+			state = "failing"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", st.Name, st.Type, state, pid, port, st.Dir)
+	}
+	_ = tw.Flush()
+	for _, st := range list {
+		if st.Degraded != "" {
+			fmt.Fprintf(w, "DEGRADED: %s: reload failed, serving previous version: %s\n", st.Name, st.Degraded)
+		}
+		if st.Conflict != "" {
+			fmt.Fprintf(w, "CONFLICT: %s\n", st.Conflict)
+		}
+	}
+}
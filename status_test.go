@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appList fetches the admin list of apps as JSON.
+func appList(t *testing.T, query string) map[string]appStatus {
+	t.Helper()
+	w := admin("GET", "/apps?format=json"+query, "")
+	var list []appStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	byName := map[string]appStatus{}
+	for _, st := range list {
+		byName[st.Name] = st
+	}
+	return byName
+}
+
+func TestStatusType(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	testApp(t, root, "worker", "")
+	writeFiles(t, root, map[string]string{
+		"site/index.html":   "",
+		"node/package.json": `{"scripts": {"start": "node index.js"}}`,
+		"empty/.keep":       "",
+	})
+	get("api.localhost", "/")
+
+	list := appList(t, "")
+	for name, want := range map[string]string{"api": "dynamic", "worker": "dynamic", "node": "dynamic", "site": "static", "empty": "static"} {
+		st := list[name]
+		if st.Type != want {
+			t.Errorf("%s is %q, want %s", name, st.Type, want)
+		}
+		if docRoot := st.DocRoot; (want == "static") != (docRoot == filepath.Join(root, name)) {
+			t.Errorf("%s has doc root %q", name, docRoot)
+		}
+	}
+	if api := list["api"]; !api.Running || api.PID == 0 || api.Port == 0 || api.Command != testBin {
+		t.Errorf("running app listed as %+v", api)
+	}
+	if worker := list["worker"]; worker.Running || worker.PID != 0 || worker.Port != 0 {
+		t.Errorf("stopped app listed as %+v", worker)
+	}
+}
+
+func TestReloadFailedKeepsServing(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "stable", "")
+	before := get("stable.localhost", "/").Body.String()
+	mu.Lock()
+	app := apps["stable"]
+	mu.Unlock()
+
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=exit\n"})
+	buf := captureLog(t, levelWarn)
+	reloadApp(app, filepath.Join(dir, ".env"))
+	waitFor(t, "the reload to fail", func() bool {
+		return strings.Contains(buf.String(), "RELOAD FAILED: stable: ")
+	})
+	mu.Lock()
+	degraded := app.degraded != "" && !app.reloading
+	mu.Unlock()
+	if !degraded {
+		t.Error("failed reload left the app not degraded")
+	}
+	mu.Lock()
+	current := apps["stable"]
+	mu.Unlock()
+	if current != app {
+		t.Fatal("failed reload replaced the running instance")
+	}
+	if after := get("stable.localhost", "/").Body.String(); after != before {
+		t.Errorf("after a failed reload served %q, want the previous instance's %q", after, before)
+	}
+	if st := appList(t, "")["stable"]; !st.Running || st.Degraded == "" {
+		t.Errorf("status %+v, want running and degraded", st)
+	}
+	if w := admin("GET", "/apps", ""); !strings.Contains(w.Body.String(), "DEGRADED: stable: reload failed, serving previous version: ") {
+		t.Errorf("status said %q", w.Body)
+	}
+}
+
+func TestHiddenDirs(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	writeFiles(t, root, map[string]string{
+		".git/index.html":         "git",
+		".cache/index.html":       "cache",
+		"node_modules/index.html": "modules",
+		".DS_Store":               "",
+		"site/index.html":         "site",
+	})
+	names, _, err := scanApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "api" || names[1] != "site" {
+		t.Errorf("apps %v, want api and site", names)
+	}
+	list := appList(t, "")
+	for _, name := range []string{".git", ".cache", "node_modules", ".DS_Store"} {
+		if _, ok := list[name]; ok {
+			t.Errorf("status lists %s", name)
+		}
+		if w := get(name+".localhost", "/"); w.Code != http.StatusNotFound {
+			t.Errorf("%s.localhost: status %d, want 404", name, w.Code)
+		}
+	}
+	if w := get("localhost", "/.git/index.html"); w.Code != http.StatusNotFound {
+		t.Errorf("apex /.git: status %d", w.Code)
+	}
+	dashboardOn = true
+	defer func() { dashboardOn = false }()
+	if body := get("localhost", "/").Body.String(); strings.Contains(body, ".git") || strings.Contains(body, "node_modules") {
+		t.Errorf("dashboard lists hidden dirs:\n%s", body)
+	}
+}
+
+func TestHealthSummary(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "api", "")
+	testApp(t, root, "worker", "")
+	testApp(t, root, "broken", "backoff: 1m\n")
+	stable := testApp(t, root, "stable", "")
+	writeFiles(t, root, map[string]string{"site/index.html": ""})
+	summary := func() (int, healthSummary) {
+		w := admin("GET", "/health/summary", "")
+		var sum healthSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &sum); err != nil {
+			t.Fatalf("%v: %s", err, w.Body)
+		}
+		return w.Code, sum
+	}
+
+	get("api.localhost", "/")
+	get("stable.localhost", "/")
+	if code, sum := summary(); code != http.StatusOK || sum != (healthSummary{"ok", 5, 2, 0, 0}) {
+		t.Errorf("healthy: %d %+v", code, sum)
+	}
+
+	writeFiles(t, filepath.Join(root, "broken"), map[string]string{".env": "MUX_TEST_BACKEND=exit\n"})
+	get("broken.localhost", "/")
+	mu.Lock()
+	app := apps["stable"]
+	mu.Unlock()
+	writeFiles(t, stable, map[string]string{".env": "MUX_TEST_BACKEND=exit\n"})
+	reloadApp(app, "test")
+	waitFor(t, "the reload to fail", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return app.degraded != "" && !app.reloading
+	})
+	if code, sum := summary(); code != http.StatusServiceUnavailable || sum != (healthSummary{"degraded", 5, 2, 1, 1}) {
+		t.Errorf("with failures: %d %+v", code, sum)
+	}
+}
+
+func TestStatusPages(t *testing.T) {
+	root := testRoot(t)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		writeFiles(t, root, map[string]string{name + "/index.html": name})
+	}
+	w := admin("GET", "/apps?format=json&offset=1&limit=2", "")
+	var page []appStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Name != "b" || page[1].Name != "c" || w.Header().Get("X-Total-Count") != "5" {
+		t.Errorf("offset=1&limit=2: %s with X-Total-Count %q, want b and c of 5", w.Body, w.Header().Get("X-Total-Count"))
+	}
+	if w := admin("GET", "/apps?format=json&offset=9", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("offset past the end: %s", w.Body)
+	}
+	if w := admin("GET", "/apps?limit=-1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("limit=-1: %d", w.Code)
+	}
+
+	writeFiles(t, root, map[string]string{"f/index.html": "f"})
+	if got := len(appList(t, "")); got != 5 {
+		t.Errorf("within the scan TTL listed %d apps, want the cached 5", got)
+	}
+	scanCache.Lock()
+	scanCache.at = time.Now().Add(-scanTTL)
+	scanCache.Unlock()
+	if got := len(appList(t, "")); got != 6 {
+		t.Errorf("after the scan TTL listed %d apps, want 6", got)
+	}
+}
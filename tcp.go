@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tcpMapping struct {
+	port string
+	app  string
+}
+
+var tcpMappings []tcpMapping
+
+func parseTCPMappings(spec string) ([]tcpMapping, error) {
+	var mappings []tcpMapping
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		port, app, ok := strings.Cut(item, ":")
+		if _, err := strconv.Atoi(port); !ok || err != nil || app == "" {
+			return nil, fmt.Errorf("BAD -tcp %q, want PORT:APP", item)
+		}
+		mappings = append(mappings, tcpMapping{port, app})
+	}
+	return mappings, nil
+}
+
+func serveTCP(m tcpMapping) {
+	ln, err := net.Listen("tcp", ":"+m.port)
+	if err != nil {
+		errorf("TCP: %s: %v", m.app, err)
+		return
+	}
+	infof("tcp://%s:%s (%s)", domain, m.port, m.app)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			errorf("TCP: %s: %v", m.app, err)
+			return
+		}
+		go forwardTCP(conn, resolveName(m.app))
+	}
+}
+
+func forwardTCP(conn net.Conn, name string) {
+	defer conn.Close()
+	dir, err := appDir(name)
+	if err != nil {
+		errorf("TCP: %v", err)
+		return
+	}
+	a, err := getApp(name, dir)
+	if err != nil {
+		errorf("TCP: %s: %v", name, err)
+		return
+	}
+	backend, err := net.DialTimeout("tcp", net.JoinHostPort(a.host, strconv.Itoa(a.port)), 5*time.Second)
+	if err != nil {
+		errorf("TCP: %s: %v", name, err)
+		return
+	}
+	defer backend.Close()
+
+	a.active.Add(1)
+	a.streams.Add(1)
+	defer a.done()
+	defer a.streams.Add(-1)
+	done := make(chan struct{}, 2)
+	go func() {
+		buf := copyBuffers.Get()
+		defer copyBuffers.Put(buf)
+		_, _ = io.CopyBuffer(backend, conn, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		buf := copyBuffers.Get()
+		defer copyBuffers.Put(buf)
+		_, _ = io.CopyBuffer(conn, backend, buf)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseTCPMappings(t *testing.T) {
+	got, err := parseTCPMappings("6379:redis, 5432:db,")
+	if want := []tcpMapping{{"6379", "redis"}, {"5432", "db"}}; err != nil || !slices.Equal(got, want) {
+		t.Errorf("parseTCPMappings = %v, %v, want %v", got, err, want)
+	}
+	for _, spec := range []string{"redis", "x:redis", "6379:"} {
+		if _, err := parseTCPMappings(spec); err == nil {
+			t.Errorf("parseTCPMappings(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestForwardTCP(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "redis", "")
+	writeFiles(t, dir, map[string]string{".env": "MUX_TEST_BACKEND=echo\n"})
+	saved := idleTTL
+	idleTTL = time.Millisecond
+	defer func() { idleTTL = saved }()
+
+	client, conn := net.Pipe()
+	forwarded := make(chan struct{})
+	go func() {
+		forwardTCP(conn, "redis")
+		close(forwarded)
+	}()
+	r := bufio.NewReader(client)
+	for _, line := range []string{"PING\n", "GET key\n"} {
+		if _, err := client.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.ReadString('\n'); err != nil || got != line {
+			t.Fatalf("echoed %q, %v, want %q", got, err, line)
+		}
+	}
+
+	// An open stream with no traffic outlasts the idle TTL.
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	app := apps["redis"]
+	idle := isIdle(app)
+	mu.Unlock()
+	if idle {
+		t.Error("app with an open stream is idle")
+	}
+	client.Close()
+	<-forwarded
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	idle = isIdle(app)
+	mu.Unlock()
+	if !idle {
+		t.Error("app not idle once the stream closed")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// responseTimeout limits how long a proxied request may take, 0 for no limit.
+var responseTimeout time.Duration
+
+const timeoutHeader = "X-Mux-Timeout"
+
+// requestTimeout is responseTimeout, or the X-Mux-Timeout duration of a
+// request from loopback or a -trusted-proxy, where 0 lifts the limit. It lets
+// a slow endpoint be debugged without restarting mux. The header never
+// reaches the app.
+func requestTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get(timeoutHeader)
+	r.Header.Del(timeoutHeader)
+	if v == "" {
+		return responseTimeout
+	}
+	addr, ok := remoteAddr(r)
+	if !ok || !addr.IsLoopback() && !containsAddr(trustedProxies, addr) {
+		return responseTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		debugf("BAD %s %q", timeoutHeader, v)
+		return responseTimeout
+	}
+	return d
+}
+
+// withTimeout bounds r by requestTimeout; the proxy answers 504 when it runs
+// out before the app responds.
+func withTimeout(r *http.Request) (*http.Request, context.CancelFunc) {
+	d := requestTimeout(r)
+	if d <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	return r.WithContext(ctx), cancel
+}
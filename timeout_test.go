@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHeader(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "slow", "")
+	responseTimeout = 100 * time.Millisecond
+	defer func() { responseTimeout = 0 }()
+	send := func(remote, path, timeout string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://slow.localhost"+path, nil)
+		r.RemoteAddr = net.JoinHostPort(remote, "40000")
+		if timeout != "" {
+			r.Header.Set(timeoutHeader, timeout)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	for _, tt := range []struct {
+		remote, timeout string
+		want            int
+	}{
+		{"127.0.0.1", "", http.StatusGatewayTimeout},
+		{"127.0.0.1", "2s", http.StatusOK},
+		{"::1", "0", http.StatusOK},
+		{"127.0.0.1", "soon", http.StatusGatewayTimeout},
+		{"192.0.2.1", "2s", http.StatusGatewayTimeout},
+	} {
+		if w := send(tt.remote, "/sleep/300ms", tt.timeout); w.Code != tt.want {
+			t.Errorf("from %s with %s %q: %d, want %d", tt.remote, timeoutHeader, tt.timeout, w.Code, tt.want)
+		}
+	}
+	if w := send("127.0.0.1", "/header/"+timeoutHeader, "2s"); w.Body.String() != "" {
+		t.Errorf("the app got %s %q", timeoutHeader, w.Body)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+var slowRequest = 5 * time.Second
+
+type timingWriter struct {
+	http.ResponseWriter
+	first time.Time
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(p []byte) (int, error) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func serveTimed(a *appInfo, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	tw := &timingWriter{ResponseWriter: w}
+	a.p.ServeHTTP(tw, r)
+	total := time.Since(start)
+	ttfb := total
+	if !tw.first.IsZero() {
+		ttfb = tw.first.Sub(start)
+	}
+	metricsFor(a.name).latency.observe(total)
+	if slowRequest > 0 && total > slowRequest {
+		warnf("SLOW: %s %s %s took %s, first byte after %s", a.name, r.Method, r.URL.Path, total.Round(time.Millisecond), ttfb.Round(time.Millisecond))
+	}
+}
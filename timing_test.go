@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowRequest(t *testing.T) {
+	root := testRoot(t)
+	testApp(t, root, "timed", "")
+	metricsMu.Lock()
+	delete(metrics, "timed")
+	metricsMu.Unlock()
+	saved := slowRequest
+	slowRequest = 100 * time.Millisecond
+	defer func() { slowRequest = saved }()
+	buf := captureLog(t, levelWarn)
+
+	get("timed.localhost", "/")
+	if strings.Contains(buf.String(), "SLOW") {
+		t.Errorf("fast request logged %q", buf)
+	}
+	get("timed.localhost", "/sleep/200ms")
+	if !strings.Contains(buf.String(), "SLOW: timed GET /sleep/200ms took ") {
+		t.Errorf("slow request logged %q, want SLOW", buf)
+	}
+
+	h := &metricsFor("timed").latency
+	if n := h.total(); n != 2 {
+		t.Errorf("%d requests timed, want 2", n)
+	}
+	if p95 := h.quantile(0.95); p95 != 250*time.Millisecond {
+		t.Errorf("p95 = %s, want the 250ms bucket", p95)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h histogram
+	if q := h.quantile(0.5); q != 0 {
+		t.Errorf("empty p50 = %s", q)
+	}
+	for range 9 {
+		h.observe(3 * time.Millisecond)
+	}
+	h.observe(time.Minute)
+	if q := h.quantile(0.5); q != 5*time.Millisecond {
+		t.Errorf("p50 = %s, want 5ms", q)
+	}
+	if q := h.quantile(0.95); q != 10*time.Second {
+		t.Errorf("p95 = %s, want the last bucket", q)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeEmail = ""
+	certFile  = ""
+	keyFile   = ""
+	acmeCache = ""
+	tlsPort   = "443"
+	http3On   = false
+
+	// httpBehavior is what the plain HTTP -port does with -acme: serve
+	// apps too, or redirect to HTTPS.
+	httpBehavior = "serve"
+)
+
+func acmeManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Email:  acmeEmail,
+		Cache:  autocert.DirCache(acmeCache),
+		HostPolicy: func(_ context.Context, host string) error {
+			if !acmeHost(host) {
+				return fmt.Errorf("UNKNOWN host %s", host)
+			}
+			return nil
+		},
+	}
+}
+
+// acmeHost reports whether to get a certificate for host: the apex, or a
+// subdomain naming an app directory. Any other name in SNI would spend the
+// Let's Encrypt rate limits.
+func acmeHost(host string) bool {
+	if host == domain {
+		return true
+	}
+	sub, ok := strings.CutSuffix(host, "."+domain)
+	if !ok {
+		return false
+	}
+	name := resolveName(subdomainName(sub))
+	if !validName(name) {
+		return false
+	}
+	dir, err := appDir(name)
+	return err == nil && isDir(dir)
+}
+
+func serveACME(h http.Handler) error {
+	if domain == "localhost" || strings.HasSuffix(domain, ".localhost") {
+		return fmt.Errorf("-acme needs a public -host, not %s", domain)
+	}
+	m := acmeManager()
+	return serveTLS(h, m.HTTPHandler(plainHandler(h)), m.TLSConfig())
+}
+
+// serveCertFiles serves HTTPS with -tls-cert and -tls-key, picking up new
+// files without a restart.
+func serveCertFiles(h http.Handler) error {
+	store := &certStore{certFile: certFile, keyFile: keyFile}
+	if err := store.load(); err != nil {
+		return err
+	}
+	go store.watch()
+	tlsConfig := &tls.Config{GetCertificate: store.get, NextProtos: []string{"h2", "http/1.1"}}
+	return serveTLS(h, plainHandler(h), tlsConfig)
+}
+
+func plainHandler(h http.Handler) http.Handler {
+	if httpBehavior == "redirect" {
+		return http.HandlerFunc(redirectHTTPS)
+	}
+	return h
+}
+
+func serveTLS(h, plain http.Handler, tlsConfig *tls.Config) error {
+	go serve(&http.Server{Handler: plain}, ":"+port)
+	if clientCert {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	if http3On {
+		h = serveHTTP3(h, tlsConfig)
+	}
+	serve(&http.Server{Handler: h, TLSConfig: tlsConfig}, ":"+tlsPort)
+	return nil
+}
+
+// certStore holds the certificate from -tls-cert and -tls-key and swaps in
+// a new one when either file changes. Handshakes in progress keep the one
+// they got.
+type certStore struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	stamp             string
+}
+
+const certPollInterval = 5 * time.Second
+
+func (s *certStore) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// fileStamp changes when either file is replaced or rewritten.
+func (s *certStore) fileStamp() string {
+	var stamp string
+	for _, file := range []string{s.certFile, s.keyFile} {
+		if fi, err := os.Stat(file); err == nil {
+			stamp += fmt.Sprintf("%d/%d;", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return stamp
+}
+
+func (s *certStore) load() error {
+	stamp := s.fileStamp()
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("BAD -tls-cert: %v", err)
+	}
+	s.cert.Store(&cert)
+	s.stamp = stamp
+	return nil
+}
+
+// watch polls rather than using fsnotify, so certificates swapped by
+// renaming a symlinked directory, as Kubernetes does, are noticed too.
+func (s *certStore) watch() {
+	for range time.Tick(certPollInterval) {
+		s.reload()
+	}
+}
+
+// reload loads the files again if they changed since the last load.
+func (s *certStore) reload() {
+	if s.fileStamp() == s.stamp {
+		return
+	}
+	if err := s.load(); err != nil {
+		// A renewal may write the two files one after the other.
+		warnf("TLS: %v, keeping the current certificate", err)
+		return
+	}
+	infof("TLS: reloaded %s", s.certFile)
+}
+
+// redirectHTTPS sends r to the same host, path and query on -tls-port.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if tlsPort != "443" {
+		host = net.JoinHostPort(host, tlsPort)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// serveHTTP3 serves h over QUIC on the UDP -tls-port and returns h wrapped
+// to advertise it with Alt-Svc. It is experimental and may change.
+func serveHTTP3(h http.Handler, tlsConfig *tls.Config) http.Handler {
+	h3 := &http3.Server{Addr: ":" + tlsPort, Handler: h, TLSConfig: http3.ConfigureTLSConfig(tlsConfig)}
+	go func() {
+		log.Fatal(h3.ListenAndServe())
+	}()
+	infof("https://%s:%s over HTTP/3 (experimental)", domain, tlsPort)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = h3.SetQUICHeaders(w.Header())
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// publicDomain serves the apps of a test root on example.com.
+func publicDomain(t *testing.T) string {
+	t.Helper()
+	root := testRoot(t)
+	domain = "example.com"
+	return root
+}
+
+func TestACMEHost(t *testing.T) {
+	root := publicDomain(t)
+	if err := os.Mkdir(filepath.Join(root, "blog"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]bool{
+		"example.com":       true,
+		"blog.example.com":  true,
+		"shop.example.com":  false,
+		".blog.example.com": false,
+		"blog.example.org":  false,
+		"example.com.evil":  false,
+	} {
+		if got := acmeHost(host); got != want {
+			t.Errorf("acmeHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestACMEManager(t *testing.T) {
+	root := publicDomain(t)
+	if err := os.Mkdir(filepath.Join(root, "blog"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var hits atomic.Int32
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"type":"urn:ietf:params:acme:error:unauthorized"}`))
+	}))
+	defer directory.Close()
+	saved := acmeCache
+	acmeCache = t.TempDir()
+	defer func() { acmeCache = saved }()
+	m := acmeManager()
+	m.Client = &acme.Client{DirectoryURL: directory.URL}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.com"}); err == nil || hits.Load() != 0 {
+		t.Errorf("unknown app: err %v after %d ACME requests, want an error before any", err, hits.Load())
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "blog.example.com"}); err == nil || hits.Load() == 0 {
+		t.Errorf("app: err %v after %d ACME requests, want the directory asked", err, hits.Load())
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	h := m.HTTPHandler(fallback)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://blog.example.com/.well-known/acme-challenge/token", nil))
+	if w.Code == http.StatusTeapot {
+		t.Error("HTTP-01 challenge went to the app")
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://blog.example.com/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("plain request got %d, want it passed to the app", w.Code)
+	}
+}
+
+func TestServeACMELocalhost(t *testing.T) {
+	testRoot(t)
+	if err := serveACME(http.NotFoundHandler()); err == nil {
+		t.Error("serveACME accepted -host localhost")
+	}
+}
+
+func TestHTTPBehavior(t *testing.T) {
+	savedBehavior, savedPort := httpBehavior, tlsPort
+	defer func() { httpBehavior, tlsPort = savedBehavior, savedPort }()
+	apps := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+
+	httpBehavior = "serve"
+	w := httptest.NewRecorder()
+	plainHandler(apps).ServeHTTP(w, httptest.NewRequest("GET", "http://blog.example.com/a?b=c", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("serve: got %d, want the app", w.Code)
+	}
+
+	httpBehavior = "redirect"
+	for tls, want := range map[string]string{
+		"443":  "https://blog.example.com/a/b?c=d&e=f",
+		"8443": "https://blog.example.com:8443/a/b?c=d&e=f",
+	} {
+		tlsPort = tls
+		w := httptest.NewRecorder()
+		plainHandler(apps).ServeHTTP(w, httptest.NewRequest("GET", "http://blog.example.com:8080/a/b?c=d&e=f", nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != want {
+			t.Errorf("redirect to port %s: %d to %q, want 301 to %q", tls, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+}
+
+// writeCert writes a self-signed certificate with serial and its key.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, filepath.Dir(certFile), map[string]string{
+		filepath.Base(certFile): string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		filepath.Base(keyFile):  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	})
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	store := &certStore{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+	writeCert(t, store.certFile, store.keyFile, 1)
+	if err := store.load(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: store.get})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	serial := func() int64 {
+		t.Helper()
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		return c.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Fatalf("first handshake got serial %d, want 1", got)
+	}
+
+	writeCert(t, store.certFile, store.keyFile, 2)
+	store.reload()
+	if got := serial(); got != 2 {
+		t.Errorf("after swapping the files got serial %d, want 2", got)
+	}
+
+	// A renewal that has written only the certificate so far.
+	writeFiles(t, dir, map[string]string{"key.pem": "partial"})
+	store.reload()
+	if got := serial(); got != 2 {
+		t.Errorf("with a broken key got serial %d, want the current 2", got)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// appTmp gives each app instance its own TMPDIR under .mux/tmp, removed
+// when the instance stops.
+var appTmp = false
+
+// makeTmp creates a temp dir for an instance about to start in dir. Its
+// name is random since the PID is only known after the start.
+func makeTmp(dir string) (string, error) {
+	parent := filepath.Join(dir, ".mux", "tmp")
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, "")
+}
+
+// removeTmp removes app's temp dir once its process has exited.
+func removeTmp(app *appInfo) {
+	if app.tmp == "" {
+		return
+	}
+	if app.exited != nil {
+		<-app.exited
+	}
+	if err := os.RemoveAll(app.tmp); err != nil {
+		warnf("TMP: %s: %v", app.name, err)
+	}
+}
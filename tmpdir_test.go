@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppTmp(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "scratch", "")
+	if w := get("scratch.localhost", "/env/TMPDIR"); strings.HasPrefix(w.Body.String(), dir) {
+		t.Errorf("TMPDIR = %q without -app-tmp", w.Body)
+	}
+	mu.Lock()
+	first := apps["scratch"]
+	mu.Unlock()
+	stopApp(first)
+	appTmp = true
+	defer func() { appTmp = false }()
+
+	tmp := get("scratch.localhost", "/env/TMPDIR").Body.String()
+	if filepath.Dir(tmp) != filepath.Join(dir, ".mux", "tmp") {
+		t.Fatalf("TMPDIR = %q, want one under .mux/tmp", tmp)
+	}
+	if got := get("scratch.localhost", "/env/MUX_TMP").Body.String(); got != tmp {
+		t.Errorf("MUX_TMP = %q, want %q", got, tmp)
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	app := apps["scratch"]
+	mu.Unlock()
+	stopApp(app)
+	waitFor(t, "the temp dir to go", func() bool {
+		_, err := os.Stat(tmp)
+		return os.IsNotExist(err)
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// One watcher serves all running apps. It is created for the first app and
+// closed with the last one, which also ends its watchLoop goroutine.
+var (
+	watchMu sync.Mutex
+	watcher *fsnotify.Watcher
+	watched = map[string]*appInfo{}
+)
+
+// loadWatch compiles the watch-ext: extensions followed by the .watch
+// patterns, so .watch can still exclude files that watch-ext: matches. It
+// also returns the exact paths in .watch, whose directories must be watched
+// even where addRecursive skips them. $VAR in .watch expands from the app's
+// .env files or mux's environment, and $$ is a literal $.
+func loadWatch(dir string, exts []string) (*ignore.GitIgnore, []string) {
+	var lines, files []string
+	for _, ext := range exts {
+		lines = append(lines, "*."+ext)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".watch"))
+	if err == nil {
+		env, _ := appEnv(dir)
+		expand := func(k string) string {
+			// go-gitignore leaves $ a regexp anchor unless escaped.
+			if k == "$" {
+				return `\$`
+			}
+			if v, ok := env[k]; ok {
+				return v
+			}
+			return getenv(k)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = os.Expand(line, expand)
+			if file, ok := exactPath(line); ok {
+				files = append(files, file)
+				line = "/" + file
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return ignore.CompileIgnoreLines(lines...), files
+}
+
+// exactPath returns line as a path relative to the app directory if it
+// names one file, like config/app.yml or ./app.yml. Such lines are anchored
+// as .gitignore does, which go-gitignore misses for a slash in the middle.
+// A bare name like app.yml stays a pattern that matches at any depth.
+func exactPath(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.ContainsAny(line, "*?[!#\\") || strings.HasSuffix(line, "/") {
+		return "", false
+	}
+	if !strings.HasPrefix(line, "./") && !strings.Contains(strings.TrimPrefix(line, "/"), "/") {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(line, "./"), "/"), true
+}
+
+// matchInverted reports whether path, relative to the app directory, is
+// watched. The last matching .watch pattern wins, like in .gitignore.
+func matchInverted(path string, ig *ignore.GitIgnore) bool {
+	if ig == nil {
+		return false
+	}
+	return ig.MatchesPath(filepath.ToSlash(path))
+}
+
+// isManifest reports whether path configures the app in dir, so changing it
+// reloads the app even when .watch doesn't match it.
+func isManifest(dir, path string) bool {
+	switch path {
+	case filepath.Join(dir, ".watch"), procfilePath(dir), filepath.Join(dir, "package.json"):
+		return true
+	}
+	return false
+}
+
+func addRecursive(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+func startWatcher(app *appInfo) {
+	var files []string
+	app.ig, files = loadWatch(app.dir, app.pf.watchExt)
+
+	watchMu.Lock()
+	if watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			watchMu.Unlock()
+			errorf("WATCH: %v", err)
+			return
+		}
+		watcher = w
+		go watchLoop(w)
+	}
+	w := watcher
+	watched[app.dir] = app
+	watchMu.Unlock()
+	_ = addRecursive(w, app.dir)
+	for _, file := range files {
+		_ = w.Add(filepath.Dir(filepath.Join(app.dir, file)))
+	}
+	if hashCheck {
+		seedHashes(app, files)
+	}
+}
+
+func stopWatcher(app *appInfo) {
+	watchMu.Lock()
+	if watcher == nil || watched[app.dir] != app {
+		watchMu.Unlock()
+		return
+	}
+	delete(watched, app.dir)
+	w := watcher
+	if len(watched) == 0 {
+		watcher = nil
+		watchMu.Unlock()
+		// Close waits for fsnotify's reader, which may be handing watchLoop
+		// the event that stopped this app.
+		go w.Close()
+		return
+	}
+	watchMu.Unlock()
+	for _, path := range w.WatchList() {
+		if path == app.dir || strings.HasPrefix(path, app.dir+string(filepath.Separator)) {
+			_ = w.Remove(path)
+		}
+	}
+}
+
+func watchedApp(path string) *appInfo {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if app, ok := watched[dir]; ok {
+			return app
+		}
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// watchLoop batches events for debounceDelay and handles each changed path
+// once, so a burst like a git checkout costs one match per file and stops
+// at the first one that reloads its app.
+func watchLoop(w *fsnotify.Watcher) {
+	pending := map[string]fsnotify.Op{}
+	var flush <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			pending[event.Name] |= event.Op
+			if flush == nil {
+				flush = time.After(debounceDelay)
+			}
+		case <-flush:
+			flushEvents(pending, func(app *appInfo, event fsnotify.Event) bool {
+				return handleEvent(w, app, event)
+			})
+			pending, flush = map[string]fsnotify.Op{}, nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// The shared watcher can't tell which app an error belongs to.
+			watchMu.Lock()
+			for _, app := range watched {
+				metricsFor(app.name).watchErrors.Add(1)
+			}
+			watchMu.Unlock()
+			errorf("WATCH: %v", err)
+		}
+	}
+}
+
+// flushEvents passes each pending path in order to handle, which reports
+// whether it reloaded the path's app, skipping apps already reloaded.
+func flushEvents(pending map[string]fsnotify.Op, handle func(*appInfo, fsnotify.Event) bool) {
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	reloaded := map[*appInfo]bool{}
+	for _, path := range paths {
+		if app := watchedApp(path); app != nil && !reloaded[app] {
+			reloaded[app] = handle(app, fsnotify.Event{Name: path, Op: pending[path]})
+		}
+	}
+}
+
+// handleEvent reports whether event reloads app.
+func handleEvent(w *fsnotify.Watcher, app *appInfo, event fsnotify.Event) bool {
+	m := metricsFor(app.name)
+	m.watchEvents.Add(1)
+	if isManifest(app.dir, event.Name) {
+		reloadChanged(app, event.Name)
+		return true
+	}
+	rel, err := filepath.Rel(app.dir, event.Name)
+	if err != nil {
+		return false
+	}
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+			if matchInverted(rel, app.ig) {
+				_ = addRecursive(w, event.Name)
+			}
+		}
+	}
+	if !matchInverted(rel, app.ig) || hashCheck && unchanged(event.Name) {
+		m.watchFiltered.Add(1)
+		return false
+	}
+	reloadChanged(app, event.Name)
+	return true
+}
+
+// reloadChanged reloads app for a changed path, or with reload: deferred
+// marks it dirty for done to reload once no requests are in flight.
+func reloadChanged(app *appInfo, path string) {
+	if app.pf.deferReload {
+		mu.Lock()
+		busy := app.active.Load() > 0
+		if busy {
+			app.dirty = path
+		}
+		mu.Unlock()
+		if busy {
+			debugf("DEFERRED: %s %s", app.name, path)
+			return
+		}
+	}
+	reloadApp(app, path)
+}
+
+// reloadApp starts a new instance of app and swaps it in once it is ready.
+// If it fails to start, app keeps serving and is marked degraded.
+func reloadApp(app *appInfo, path string) {
+	// Both instances can't listen on a fixed-port:, so those apps stop first.
+	swap := app.pf.fixedPort == 0
+	mu.Lock()
+	busy := app.reloading || app.stopping
+	app.reloading = swap
+	mu.Unlock()
+	if busy {
+		return
+	}
+	debugf("UPDATED: %s", path)
+	metricsFor(app.name).watchReloads.Add(1)
+	runHook(app, "reload")
+	if !swap {
+		stopApp(app)
+		return
+	}
+	go func() {
+		next, err := start(app.name)
+		mu.Lock()
+		app.reloading = false
+		if err != nil {
+			app.degraded = err.Error()
+			mu.Unlock()
+			warnf("RELOAD FAILED: %s: %v, serving previous version", app.name, err)
+			return
+		}
+		stale := app
+		if apps[app.name] == app {
+			apps[app.name] = next
+		} else {
+			stale = next
+		}
+		mu.Unlock()
+		stopApp(stale)
+	}()
+}
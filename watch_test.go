@@ -0,0 +1,444 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// watchApp registers a fake app at dir watching patterns for the test.
+func watchApp(t testing.TB, dir string, patterns ...string) *appInfo {
+	app := &appInfo{name: filepath.Base(dir), dir: dir, ig: ignore.CompileIgnoreLines(patterns...)}
+	watchMu.Lock()
+	watched[dir] = app
+	watchMu.Unlock()
+	t.Cleanup(func() {
+		watchMu.Lock()
+		delete(watched, dir)
+		watchMu.Unlock()
+	})
+	return app
+}
+
+// burst is what a checkout of n files sends: create, write and chmod each,
+// with vendored files, which .watch excludes, sorting first.
+func burst(dir string, n int) []fsnotify.Event {
+	var events []fsnotify.Event
+	for i := range n {
+		name := filepath.Join(dir, fmt.Sprintf("src/f%03d.go", i))
+		if i < n/2 {
+			name = filepath.Join(dir, fmt.Sprintf("node_modules/f%03d.go", i))
+		}
+		for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Chmod} {
+			events = append(events, fsnotify.Event{Name: name, Op: op})
+		}
+	}
+	return events
+}
+
+// burstMatches returns how many paths are matched against .watch for one
+// burst, handling each event as it comes or batched by flushEvents.
+func burstMatches(app *appInfo, events []fsnotify.Event, batched bool) int {
+	matches := 0
+	match := func(app *appInfo, event fsnotify.Event) bool {
+		matches++
+		rel, _ := filepath.Rel(app.dir, event.Name)
+		return matchInverted(rel, app.ig)
+	}
+	if !batched {
+		for _, event := range events {
+			match(watchedApp(event.Name), event)
+		}
+		return matches
+	}
+	pending := map[string]fsnotify.Op{}
+	for _, event := range events {
+		pending[event.Name] |= event.Op
+	}
+	flushEvents(pending, match)
+	return matches
+}
+
+func TestFlushEvents(t *testing.T) {
+	app := watchApp(t, "/srv/app", "*.go", "!node_modules/")
+	events := burst(app.dir, 10)
+	var got []fsnotify.Event
+	pending := map[string]fsnotify.Op{}
+	for _, event := range events {
+		pending[event.Name] |= event.Op
+	}
+	flushEvents(pending, func(_ *appInfo, event fsnotify.Event) bool {
+		got = append(got, event)
+		rel, _ := filepath.Rel(app.dir, event.Name)
+		return matchInverted(rel, app.ig)
+	})
+	// Five excluded files, then the first watched one reloads the app.
+	if len(got) != 6 {
+		t.Fatalf("handled %d paths, want 6: %v", len(got), got)
+	}
+	want := fsnotify.Create | fsnotify.Write | fsnotify.Chmod
+	if last := got[5]; last.Name != "/srv/app/src/f005.go" || last.Op != want {
+		t.Errorf("last event = %v, want /srv/app/src/f005.go with %v", last, want)
+	}
+}
+
+func BenchmarkWatchBurst(b *testing.B) {
+	app := watchApp(b, "/srv/app", "*.go", "!node_modules/")
+	events := burst(app.dir, 500)
+	for _, batched := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batched=%t", batched), func(b *testing.B) {
+			matches := 0
+			for b.Loop() {
+				matches = burstMatches(app, events, batched)
+			}
+			b.ReportMetric(float64(matches), "matches/op")
+		})
+	}
+	if each, batched := burstMatches(app, events, false), burstMatches(app, events, true); batched >= each {
+		b.Errorf("batched burst matched %d paths, unbatched %d", batched, each)
+	}
+}
+
+func TestSharedWatcher(t *testing.T) {
+	root := testRoot(t)
+	started := map[string]*appInfo{}
+	for _, name := range []string{"left", "right"} {
+		dir := testApp(t, root, name, "")
+		writeFiles(t, dir, map[string]string{".watch": "*.txt\n"})
+		app, err := getApp(name, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		started[name] = app
+		metricsMu.Lock()
+		delete(metrics, name)
+		metricsMu.Unlock()
+	}
+	watchMu.Lock()
+	shared := watcher
+	n := len(watched)
+	watchMu.Unlock()
+	if shared == nil || n != 2 {
+		t.Fatalf("watcher %v watching %d apps, want one for both", shared, n)
+	}
+
+	writeFiles(t, started["left"].dir, map[string]string{"notes.txt": "x"})
+	waitFor(t, "left to reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["left"] != started["left"]
+	})
+	time.Sleep(2 * debounceDelay)
+	mu.Lock()
+	right := apps["right"]
+	mu.Unlock()
+	if right != started["right"] || metricsFor("right").watchReloads.Load() != 0 {
+		t.Error("right reloaded for a change in left")
+	}
+
+	watchMu.Lock()
+	same := watcher == shared
+	watchMu.Unlock()
+	if !same {
+		t.Error("reloading left replaced the shared watcher")
+	}
+}
+
+func TestWatcherClosedWithLastApp(t *testing.T) {
+	left := watchApp(t, "/srv/left")
+	right := watchApp(t, "/srv/right")
+	// watchApp registers apps without a watcher; give them one as
+	// startWatcher would.
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchMu.Lock()
+	watcher = w
+	watchMu.Unlock()
+	stopWatcher(left)
+	watchMu.Lock()
+	open := watcher != nil
+	watchMu.Unlock()
+	if !open {
+		t.Fatal("watcher closed while right still runs")
+	}
+	stopWatcher(right)
+	watchMu.Lock()
+	open = watcher != nil
+	watchMu.Unlock()
+	if open {
+		t.Error("watcher still open after the last app stopped")
+	}
+}
+
+func TestMatchInverted(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".watch": "src/**\n!src/generated/**\nsrc/generated/keep.go\n*.md\n!docs/\n"})
+	ig, files := loadWatch(dir, nil)
+	if len(files) != 1 || files[0] != "src/generated/keep.go" {
+		t.Errorf("exact files = %q, want src/generated/keep.go", files)
+	}
+	for path, want := range map[string]bool{
+		"src/main.go":              true,
+		"src/lib/util.go":          true,
+		"src/generated/api.go":     false,
+		"src/generated/keep.go":    true,
+		"lib/generated/keep.go":    false,
+		"README.md":                true,
+		"pkg/notes.md":             true,
+		"docs/index.md":            false,
+		"main.go":                  false,
+		"src/generated/deep/x.go":  false,
+		"other/src/generated/x.go": false,
+	} {
+		if got := matchInverted(path, ig); got != want {
+			t.Errorf("matchInverted(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestExactPath(t *testing.T) {
+	for line, want := range map[string]string{
+		"config/app.yml":  "config/app.yml",
+		"./app.yml":       "app.yml",
+		"/config/app.yml": "config/app.yml",
+		"/app.yml":        "",
+		"app.yml":         "",
+		"src/*.go":        "",
+		"!config/x.yml":   "",
+		"config/":         "",
+	} {
+		got, ok := exactPath(line)
+		if got != want || ok != (want != "") {
+			t.Errorf("exactPath(%q) = %q, %v, want %q", line, got, ok, want)
+		}
+	}
+}
+
+func TestWatchExt(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "ext", "watch-ext: go\n")
+	writeFiles(t, dir, map[string]string{".watch": "!gen.go\n"})
+	app, err := getApp("ext", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["ext"] != app
+	}
+
+	writeFiles(t, dir, map[string]string{"README.md": "x", "gen.go": "package main\n"})
+	time.Sleep(2 * debounceDelay)
+	if reloaded() {
+		t.Fatal("reloaded for a .md change or a .go file .watch excludes")
+	}
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	waitFor(t, "a .go change to reload", reloaded)
+}
+
+func TestWatcherGoroutines(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "cycled", "")
+	writeFiles(t, dir, map[string]string{".watch": "*.txt\n"})
+	cycle := func() {
+		app, err := getApp("cycled", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stopApp(app)
+		<-app.exited
+	}
+	cycle()
+	runtime.GC()
+	before := runtime.NumGoroutine()
+	for range 10 {
+		cycle()
+	}
+	var after int
+	waitFor(t, "goroutines to exit", func() bool {
+		after = runtime.NumGoroutine()
+		return after <= before+2
+	})
+	watchMu.Lock()
+	open := watcher != nil
+	watchMu.Unlock()
+	if open {
+		t.Error("watcher still open with no app running")
+	}
+}
+
+func TestHashCheck(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "hashed", "")
+	writeFiles(t, dir, map[string]string{".watch": "*.txt\n", "existing.txt": "old"})
+	hashCheck = true
+	defer func() { hashCheck = false }()
+	app, err := getApp("hashed", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(file, content string) (reloaded bool) {
+		writeFiles(t, dir, map[string]string{file: content})
+		deadline := time.Now().Add(4 * debounceDelay)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			next := apps["hashed"]
+			mu.Unlock()
+			if next != nil && next != app {
+				app = next
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if write("existing.txt", "old") {
+		t.Error("rewriting a file from before the start reloaded")
+	}
+	if !write("notes.txt", "one") {
+		t.Fatal("new file did not reload")
+	}
+	if write("notes.txt", "one") {
+		t.Error("identical content reloaded")
+	}
+	if !write("notes.txt", "two") {
+		t.Error("changed content did not reload")
+	}
+}
+
+func TestHashCacheBounded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f")
+	writeFiles(t, dir, map[string]string{"f": "x"})
+	hashMu.Lock()
+	clear(hashes)
+	for i := range maxHashes {
+		hashes[fmt.Sprint(i)] = [32]byte{}
+	}
+	hashMu.Unlock()
+	if unchanged(path) || !unchanged(path) {
+		t.Error("unchanged: want false when first seen, then true")
+	}
+	hashMu.Lock()
+	n := len(hashes)
+	clear(hashes)
+	hashMu.Unlock()
+	if n > maxHashes {
+		t.Errorf("%d hashes cached, want at most %d", n, maxHashes)
+	}
+}
+
+func TestProcfileEdit(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "edited", "")
+	if got := args(t, "edited.localhost"); got != "" {
+		t.Fatalf("args = %q before the edit", got)
+	}
+	mu.Lock()
+	app := apps["edited"]
+	mu.Unlock()
+	writeFiles(t, dir, map[string]string{"Procfile": "web: " + testBin + " edited\n"})
+	waitFor(t, "the Procfile edit to reload", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["edited"] != app
+	})
+	if got := args(t, "edited.localhost"); got != "edited" {
+		t.Errorf("args = %q after the edit, want the new web: command's", got)
+	}
+}
+
+func TestWatchExactFile(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "configured", "")
+	writeFiles(t, dir, map[string]string{
+		".watch":           "config/app.yml\n",
+		"config/app.yml":   "a: 1\n",
+		"config/other.yml": "b: 1\n",
+	})
+	app, err := getApp("configured", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["configured"] != app
+	}
+	writeFiles(t, dir, map[string]string{"config/other.yml": "b: 2\n", "app.yml": "c: 1\n"})
+	time.Sleep(2 * debounceDelay)
+	if reloaded() {
+		t.Fatal("reloaded for a file .watch doesn't name")
+	}
+	writeFiles(t, dir, map[string]string{"config/app.yml": "a: 2\n"})
+	waitFor(t, "config/app.yml to reload", reloaded)
+}
+
+func TestDeferredReload(t *testing.T) {
+	root := testRoot(t)
+	dir := testApp(t, root, "compiled", "reload: deferred\n")
+	writeFiles(t, dir, map[string]string{".watch": "*.txt\n"})
+	get("compiled.localhost", "/")
+	mu.Lock()
+	app := apps["compiled"]
+	mu.Unlock()
+	reloaded := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return apps["compiled"] != app
+	}
+
+	served := make(chan int)
+	go func() { served <- get("compiled.localhost", "/sleep/600ms").Code }()
+	waitFor(t, "the request to be in flight", func() bool { return app.active.Load() > 0 })
+	writeFiles(t, dir, map[string]string{"notes.txt": "x"})
+	time.Sleep(3 * debounceDelay)
+	mu.Lock()
+	dirty := app.dirty
+	mu.Unlock()
+	if reloaded() || dirty == "" {
+		t.Fatalf("reloaded %v, dirty %q with a request in flight, want deferred", reloaded(), dirty)
+	}
+	if code := <-served; code != http.StatusOK {
+		t.Errorf("request in flight: status %d", code)
+	}
+	waitFor(t, "the deferred reload", reloaded)
+
+	// Without requests in flight a change reloads at once.
+	mu.Lock()
+	app = apps["compiled"]
+	mu.Unlock()
+	writeFiles(t, dir, map[string]string{"notes.txt": "y"})
+	waitFor(t, "an idle app to reload", reloaded)
+}
+
+func TestWatchExpand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MUX_TEST_SRC", "from-env")
+	writeFiles(t, dir, map[string]string{
+		".env":   "SRC=lib\n",
+		".watch": "$SRC/*.go\n${MUX_TEST_SRC}/*.go\ncost$$.txt\n$UNSET_VAR/x.go\n",
+	})
+	ig, _ := loadWatch(dir, nil)
+	for path, want := range map[string]bool{
+		"lib/a.go":      true,
+		"from-env/b.go": true,
+		"src/a.go":      false,
+		"cost$.txt":     true,
+		"cost.txt":      false,
+		"x.go":          true,
+	} {
+		if got := matchInverted(path, ig); got != want {
+			t.Errorf("matchInverted(%q) = %v, want %v", path, got, want)
+		}
+	}
+}